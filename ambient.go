@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ambientLight tracks the brightness of the scene in front of the camera, and the amount to scale the brightness
+// of the lighting devices by to compensate for it. The luminance is smoothed so that brief changes, like the
+// headlights of a passing car, don't cause the orb to flicker.
+type ambientLight struct {
+	mutex     sync.Mutex
+	curve     func(level float64) float64
+	smoothing float64
+	luminance float64
+	updated   time.Time
+}
+
+// newAmbientLight creates an ambient light tracker from the configuration. Returns an error if the ambient
+// curve is invalid.
+func newAmbientLight(config AmbientConfiguration) (*ambientLight, error) {
+	curve, err := newCurve(config.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ambientLight{curve: curve, smoothing: config.Smoothing}, nil
+}
+
+// update adds a new measurement of the mean scene luminance, between 0.0 (dark) and 1.0 (bright).
+func (a *ambientLight) update(luminance float64, now time.Time) {
+	if a == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.updated.IsZero() || a.smoothing <= 0.0 {
+		a.luminance = luminance
+	} else {
+		alpha := 1.0 - math.Exp(-now.Sub(a.updated).Seconds()/a.smoothing)
+		a.luminance += alpha * (luminance - a.luminance)
+	}
+	a.updated = now
+}
+
+// level returns the smoothed scene luminance, and false if the camera hasn't measured it yet.
+func (a *ambientLight) level() (luminance float64, measured bool) {
+	if a == nil {
+		return 0.0, false
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.luminance, !a.updated.IsZero()
+}
+
+// scale returns the amount to scale the brightness of the lighting devices by for the current ambient light.
+// Without a camera there is nothing to compensate for, and the brightness is left alone.
+func (a *ambientLight) scale() float32 {
+	luminance, measured := a.level()
+	if !measured {
+		return 1.0
+	}
+
+	return clampLevel(float32(a.curve(luminance)))
+}
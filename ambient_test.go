@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAmbientCompensation(t *testing.T) {
+	curve := CurveConfiguration{"points", 0.0, [][2]float64{{0.0, 0.25}, {0.5, 1.0}}}
+	ambient, err := newAmbientLight(AmbientConfiguration{curve, 10.0})
+	if err != nil {
+		t.Fatalf("returned error for a valid ambient configuration.")
+	}
+
+	if ambient.scale() != 1.0 {
+		t.Errorf("brightness scaled before the camera measured the ambient light.")
+	}
+
+	now := time.Now()
+	ambient.update(0.0, now)
+	if ambient.scale() != 0.25 {
+		t.Errorf("brightness not scaled down in the dark.")
+	}
+
+	// A second of headlights should barely change the brightness.
+	ambient.update(1.0, now.Add(1*time.Second))
+	if ambient.scale() > 0.4 {
+		t.Errorf("ambient light not smoothed.")
+	}
+}
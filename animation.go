@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	glowFalloff   = 0.25
+	rippleDelay   = 0.5
+	startupPeriod = 4.0
+)
+
+// pixelPosition is the location of a single LED within the orb, with the centre of the orb at the origin and
+// the outermost pixels a distance of 1.0 from it.
+type pixelPosition struct {
+	x float64
+	y float64
+}
+
+// pixelLayout returns the position of each pixel in the nominated layout. A "strip" is a straight line of
+// pixels that runs through the centre of the orb, while a "ring" is a circle of pixels around the centre.
+func pixelLayout(layout string, pixels int) ([]pixelPosition, error) {
+	if pixels < 1 {
+		return nil, errors.New("an orb needs at least one pixel")
+	}
+
+	positions := make([]pixelPosition, pixels)
+	for i := range positions {
+		switch layout {
+		case "strip":
+			if pixels > 1 {
+				positions[i] = pixelPosition{2.0*float64(i)/float64(pixels-1) - 1.0, 0.0}
+			}
+
+		case "ring":
+			angle := 2.0 * math.Pi * float64(i) / float64(pixels)
+			positions[i] = pixelPosition{math.Cos(angle), math.Sin(angle)}
+
+		default:
+			return nil, fmt.Errorf("unknown pixel layout '%s'", layout)
+		}
+	}
+
+	return positions, nil
+}
+
+// orbAnimation renders the lighting sequence for each state of the neurone from the commands sent by the state
+// machine. It is used by every lighting output that doesn't leave the animation up to the arduino firmware.
+type orbAnimation struct {
+	mutex    sync.Mutex
+	look     *look
+	command  byte
+	argument float32
+	energy   float32
+	started  time.Time
+}
+
+// update starts the animation for the supplied arduino command.
+func (a *orbAnimation) update(command byte, argument float32, now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if command != a.command || command == 'p' {
+		a.started = now
+	}
+
+	if command == 'e' {
+		a.energy = argument
+	}
+
+	a.command = command
+	a.argument = argument
+}
+
+// level returns the brightness of the orb at the nominated time, between 0.0 (dark) and 1.0 (full brightness).
+// Outputs with a single fixture use the brightness at the centre of the orb.
+func (a *orbAnimation) level(now time.Time) float32 {
+	return a.pixelLevel(now, pixelPosition{0.0, 0.0})
+}
+
+// current returns the state being animated and the brightness of the centre of the orb at the nominated time.
+func (a *orbAnimation) current(now time.Time) (command byte, level float32) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.render(now, pixelPosition{0.0, 0.0})
+}
+
+// channels returns the red, green and blue channel values for the centre of the orb at the nominated time.
+func (a *orbAnimation) channels(now time.Time) []byte {
+	return a.pixelChannels(now, pixelPosition{0.0, 0.0})
+}
+
+// pixelChannels returns the red, green and blue channel values for the pixel at the nominated position and time.
+func (a *orbAnimation) pixelChannels(now time.Time, position pixelPosition) []byte {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	command, level := a.render(now, position)
+	return a.look.channels(command, level)
+}
+
+// pixelLevel returns the brightness of the pixel at the nominated position and time.
+func (a *orbAnimation) pixelLevel(now time.Time, position pixelPosition) float32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	_, level := a.render(now, position)
+	return level
+}
+
+// render returns the state being animated and the brightness of the pixel at the nominated position and time.
+// The powerup state hands back to the accumulate state once the powerup animation has finished.
+func (a *orbAnimation) render(now time.Time, position pixelPosition) (command byte, level float32) {
+
+	distance := math.Hypot(position.x, position.y)
+	dt := now.Sub(a.started).Seconds()
+
+	switch a.command {
+	case 'e':
+		// The orb glows brighter as the neurone accumulates energy, the glow is strongest at the centre.
+		return 'e', clampLevel(a.energy * float32(1.0-glowFalloff*distance))
+
+	case 'c':
+		// The orb starts at full brightness after firing and fades out over the cooldown, the outside of the
+		// orb fading out before the centre.
+		t := float64(clampLevel(a.argument))
+		return 'c', clampLevel(float32((1.0 - t) * (1.0 - 0.5*distance*t)))
+
+	case 'p':
+		if dt >= powerupLength {
+			return 'e', clampLevel(a.energy * float32(1.0-glowFalloff*distance))
+		}
+
+		// Flash to full brightness, pulsing once a second while fading back to the energy of the neurone. Each
+		// pulse ripples outwards from the centre of the orb.
+		fade := float32(1.0 - dt/powerupLength)
+		pulse := float32(0.5 + 0.5*math.Cos(2.0*math.Pi*(dt-distance*rippleDelay)))
+		return 'p', clampLevel(a.energy + (1.0-a.energy)*fade*pulse)
+
+	case 's':
+		// The orb starts at full brightness and slowly fades out over the startup sequence, while a highlight
+		// sweeps around the orb.
+		t := float64(clampLevel(a.argument))
+		angle := math.Atan2(position.y, position.x) - 2.0*math.Pi*dt/startupPeriod
+		sweep := 0.6 + 0.4*distance*math.Cos(angle)
+		return 's', clampLevel(float32((1.0 - t) * sweep))
+	}
+
+	// The neurone is waiting for startup, the orb remains dark.
+	return a.command, 0.0
+}
+
+// renderPixels renders the orb animation at the nominated time into a frame of 8 bit red, green and blue values,
+// three bytes for each pixel in the layout.
+func renderPixels(animation *orbAnimation, positions []pixelPosition, now time.Time) []byte {
+	frame := make([]byte, 0, len(positions)*len(orbColour))
+	for _, p := range positions {
+		frame = append(frame, animation.pixelChannels(now, p)...)
+	}
+
+	return frame
+}
+
+// clampLevel restricts a brightness level to be between 0.0 and 1.0.
+func clampLevel(level float32) float32 {
+	return float32(math.Min(math.Max(float64(level), 0.0), 1.0))
+}
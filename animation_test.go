@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestOrbAnimation(t *testing.T) {
+	var a orbAnimation
+	now := time.Now()
+
+	if a.level(now) != 0.0 {
+		t.Errorf("orb should be dark while waiting for startup.")
+	}
+
+	a.update('e', 0.5, now)
+	if a.level(now) != 0.5 {
+		t.Errorf("orb brightness should match the energy of the neurone.")
+	}
+
+	a.update('c', 0.25, now)
+	if a.level(now) != 0.75 {
+		t.Errorf("orb should fade out over the cooldown.")
+	}
+
+	a.update('p', 0.0, now)
+	if a.level(now) != 1.0 {
+		t.Errorf("orb should flash to full brightness on powerup.")
+	}
+
+	if a.level(now.Add(powerupLength*time.Second)) != 0.5 {
+		t.Errorf("orb should return to the energy level after powerup.")
+	}
+}
+
+func TestStartupAnimation(t *testing.T) {
+	var a orbAnimation
+	now := time.Now()
+
+	a.update('s', 0.0, now)
+	if a.level(now) == 0.0 {
+		t.Errorf("orb should be lit at the start of the startup sequence.")
+	}
+
+	a.update('s', 1.0, now)
+	if a.level(now) != 0.0 {
+		t.Errorf("orb should be dark at the end of the startup sequence.")
+	}
+}
+
+// nopCloser turns a buffer into a serial port that the arduino commands can be written to.
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (n nopCloser) Close() error {
+	return nil
+}
+
+func TestPixelFrameDecoding(t *testing.T) {
+	var commands []byte
+	var frames [][]byte
+
+	decoder := commandDecoder{
+		handle: func(command byte, argument float32) error {
+			commands = append(commands, command)
+			return nil
+		},
+		handleFrame: func(frame []byte) error {
+			frames = append(frames, frame)
+			return nil
+		},
+	}
+
+	frame := []byte{1, 2, 3, 4, 5, 6}
+	buf := new(bytes.Buffer)
+	port := nopCloser{buf}
+
+	updateArduinoEnergy(0.5, port)
+	sendPixelFrame(frame, port)
+	powerupArduino(port)
+
+	// Feed the stream to the decoder a byte at a time, like a slow serial connection.
+	for _, b := range buf.Bytes() {
+		decoder.Write([]byte{b})
+	}
+
+	if !bytes.Equal(commands, []byte{'e', 'p'}) {
+		t.Errorf("commands either side of a pixel frame were not decoded.")
+	}
+
+	if len(frames) != 1 || !bytes.Equal(frames[0], frame) {
+		t.Errorf("pixel frame was not decoded.")
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	artNetPort    = "6454"
+	artNetOpDmx   = 0x5000
+	artNetVersion = 14
+)
+
+// artNet transmits the brightness of the orb to a DMX controller as ArtDmx packets.
+type artNet struct {
+	conn     net.Conn
+	universe uint16
+	channel  int
+	sequence uint8
+}
+
+// newArtNetOutput creates a lighting output that drives the RGB fixture at the DMX address and universe
+// nominated in the configuration. Returns an error if the configuration is invalid or the controller address
+// can't be resolved.
+func newArtNetOutput(config ArtNetConfiguration, look *look) (io.ReadWriteCloser, error) {
+	if !validDMXChannel(config.Channel) {
+		return nil, errors.New("DMX channel out of range")
+	}
+
+	if config.Universe > 0x7fff {
+		return nil, errors.New("Art-Net universe out of range")
+	}
+
+	address := config.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, artNetPort)
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &artNet{conn: conn, universe: config.Universe, channel: config.Channel}
+	return newAnimatedOutput("artnet "+config.Address, dmxFrameRate, look, a.draw, conn), nil
+}
+
+// draw sends a single DMX frame lighting the orb as it appears at the nominated time.
+func (a *artNet) draw(animation *orbAnimation, now time.Time) error {
+	data := dmxUniverse(a.channel, animation.channels(now))
+
+	// Sequence numbers run from 1 to 255, zero disables sequencing on the receiver.
+	a.sequence++
+	if a.sequence == 0 {
+		a.sequence = 1
+	}
+
+	_, err := a.conn.Write(artDmxPacket(a.universe, a.sequence, data))
+	return err
+}
+
+// artDmxPacket packages the DMX channel data for the nominated universe into an ArtDmx packet.
+func artDmxPacket(universe uint16, sequence uint8, data []byte) []byte {
+	packet := new(bytes.Buffer)
+
+	packet.WriteString("Art-Net\x00")
+	binary.Write(packet, binary.LittleEndian, uint16(artNetOpDmx))
+	binary.Write(packet, binary.BigEndian, uint16(artNetVersion))
+	packet.WriteByte(sequence)
+	packet.WriteByte(0)                   // Physical input port.
+	packet.WriteByte(byte(universe))      // SubUni, the low byte of the port address.
+	packet.WriteByte(byte(universe >> 8)) // Net, the high seven bits of the port address.
+	binary.Write(packet, binary.BigEndian, uint16(len(data)))
+	packet.Write(data)
+
+	return packet.Bytes()
+}
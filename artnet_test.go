@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestArtNetOutput(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to open local UDP listener.")
+	}
+	defer listener.Close()
+
+	look, _ := newLook(defaultConfiguration().Lighting)
+	output, err := newArtNetOutput(ArtNetConfiguration{listener.LocalAddr().String(), 0x0102, 10, DeviceConfiguration{"test", 1.0, 0.0}}, look)
+	if err != nil {
+		t.Fatalf("unable to create Art-Net output: %s", err)
+	}
+	defer output.Close()
+
+	updateArduinoEnergy(1.0, output)
+
+	// Skip any frames that were sent before the energy update arrived.
+	packet := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		listener.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, _, err := listener.ReadFrom(packet)
+		if err != nil {
+			t.Fatalf("did not receive an Art-Net packet.")
+		}
+
+		if n != 18+dmxChannels {
+			t.Fatalf("incorrect Art-Net packet length %d", n)
+		}
+
+		if packet[18+9] != 0 {
+			break
+		}
+	}
+
+	if !bytes.Equal(packet[0:10], []byte("Art-Net\x00\x00\x50")) {
+		t.Errorf("incorrect Art-Net header.")
+	}
+
+	if packet[14] != 0x02 || packet[15] != 0x01 {
+		t.Errorf("incorrect Art-Net universe.")
+	}
+
+	if !bytes.Equal(packet[18+9:18+12], look.channels('e', 1.0)) {
+		t.Errorf("incorrect DMX channel values for full energy.")
+	}
+
+	if packet[18+8] != 0 || packet[18+12] != 0 {
+		t.Errorf("DMX channels outside the fixture were set.")
+	}
+}
@@ -23,10 +23,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"github.com/huin/goserial"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"strings"
 	"time"
 )
@@ -38,14 +36,17 @@ const (
 	cooldownLength = 20.0
 	powerupLength  = 26.0
 	nanoToSeconds  = 1000000000.0
+	animationStep  = 250 * time.Millisecond
 )
 
 type Neurone struct {
 	energy   float32
-	deltaE   chan float32
+	inputs   *inputBus
 	duration float64
 	start    int64
 	config   Configuration
+	peers    *peerClient
+	monitor  *monitor
 }
 
 type stateFn func(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, newNeurone Neurone)
@@ -75,6 +76,26 @@ func sendArduinoCommand(command byte, argument float32, serialPort io.ReadWriteC
 	return nil
 }
 
+// sendPixelFrame transmits a frame of pixels over the nominated serial port to the arduino. Returns an error on
+// failure. The frame holds the red, green and blue value of each pixel, and is sent as a single 'f' command
+// followed by the number of pixels and the frame itself.
+func sendPixelFrame(frame []byte, serialPort io.ReadWriteCloser) error {
+	if serialPort == nil {
+		return nil
+	}
+
+	bufOut := new(bytes.Buffer)
+	bufOut.WriteByte('f')
+	err := binary.Write(bufOut, binary.LittleEndian, uint16(len(frame)/3))
+	if err != nil {
+		return err
+	}
+	bufOut.Write(frame)
+
+	_, err = serialPort.Write(bufOut.Bytes())
+	return err
+}
+
 // updateArduinoEnergy transmits a new energy level over the nominated serial port to the arduino. Returns an error
 // on failure, nil otherwise. Arduino code takes the energy level and turns it into a lighting sequence.
 func updateArduinoEnergy(energy float32, serialPort io.ReadWriteCloser) error {
@@ -87,6 +108,12 @@ func cooldownArduino(energy float32, serialPort io.ReadWriteCloser) error {
 	return sendArduinoCommand('c', energy, serialPort)
 }
 
+// startupArduino updates the startup lighting sequence on the arduino. Returns an error on failure, nil
+// otherwise.
+func startupArduino(progress float32, serialPort io.ReadWriteCloser) error {
+	return sendArduinoCommand('s', progress, serialPort)
+}
+
 // powerupArduino puts the arduino into a short powerup animation, indicating that the neurone has recieved a
 // large burst of energy. Returns an error on failure, nil otherwise.
 func powerupArduino(serialPort io.ReadWriteCloser) error {
@@ -119,67 +146,85 @@ func wait(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, newNeuron
 
 	if neurone.config.MasterNeurone {
 		// Drain off an ignore energy from the dendrites.
-		select {
-		case <-neurone.deltaE:
-		case <-time.After(5 * time.Millisecond):
-		}
+		neurone.inputs.receiveWithin(5 * time.Millisecond)
 
 		if dt >= neurone.duration {
+			start := impulse{neurone.config.Name, 0.0, controlImpulse, time.Now(), newCascadeID()}
 			for _, adjacent := range neurone.config.AllNeurones {
-				buf := new(bytes.Buffer)
-				fmt.Fprintf(buf, "%s?e=%f", adjacent.Address, adjacent.Transfer)
-
-				address := buf.String()
-				go http.Get(address)
-				fmt.Printf("INFO: S[" + address + "]\n")
+				neurone.peers.fireInBackground(adjacent.Address, start)
+				fmt.Printf("INFO: S[" + adjacent.Address + "]\n")
 			}
 
-			return startup, Neurone{0.0, neurone.deltaE, startupLength, time.Now().UnixNano(), neurone.config}
+			return startup, Neurone{0.0, neurone.inputs, startupLength, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 		}
 	} else {
 		// Neurone is not the master, wait to be notified by the master before startup.
-		de := <-neurone.deltaE
-		if de < -0.5 {
-			return startup, Neurone{0.0, neurone.deltaE, startupLength, time.Now().UnixNano(), neurone.config}
+		// Older masters signal the startup with a large inhibitory impulse. Stop waiting now and then, so the
+		// wait timeout is seen even when the dendrites are quiet.
+		in, _ := neurone.inputs.receiveWithin(animationStep)
+		de := in.Impulse
+		if de.Kind == controlImpulse || de.delta() < -0.5 {
+			return startup, Neurone{0.0, neurone.inputs, startupLength, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 		} else if dt >= waitTimeout {
 
 			// If for some reason we don't get notified by the master neurone to enter the animation, just jump
 			// straight to interactive mode.
-			return accumulate, Neurone{0.0, neurone.deltaE, 0.0, time.Now().UnixNano(), neurone.config}
+			return accumulate, Neurone{0.0, neurone.inputs, 0.0, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 		}
 	}
 
-	return wait, Neurone{-2.0, neurone.deltaE, neurone.duration, neurone.start, neurone.config}
+	return wait, Neurone{-2.0, neurone.inputs, neurone.duration, neurone.start, neurone.config, neurone.peers, neurone.monitor}
 }
 
 // startup puts the neurone through a non-interactive animated sequence before entering the animated
 // mode.
 func startup(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, newNeurone Neurone) {
+	dt := calcDt(neurone)
+
+	// LERP the progress of the startup sequence from 0.0 to 1.0 over the duration of the startup.
+	newEnergy := float32(dt / neurone.duration)
+
+	// If the time elapsed is longer than the duration of the startup, enter the accumulate state.
+	if dt >= neurone.duration {
+		return accumulate, Neurone{0.0, neurone.inputs, 0.0, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
+	}
 
-	// The startup animation and cooldown animation are the same, just over different durations.
-	return cooldown(neurone, serialPort)
+	startupArduino(newEnergy, serialPort)
+	return startup, Neurone{newEnergy, neurone.inputs, neurone.duration, neurone.start, neurone.config, neurone.peers, neurone.monitor}
+}
+
+// fire sends the energy of the neurone into the axon (the web dendrites of adjacent neurones) as part of the
+// nominated cascade, and enters the cooldown state.
+func fire(neurone Neurone, cascade string) (sF stateFn, newNeurone Neurone) {
+	for _, adjacent := range neurone.config.AdjacentNeurones {
+		neurone.peers.fireInBackground(adjacent.Address, newImpulse(neurone.config.Name, adjacent.Transfer, cascade))
+		fmt.Printf("INFO: a[" + adjacent.Address + "]\n")
+	}
+	neurone.monitor.fired(time.Now())
+
+	fmt.Printf("INFO: cooldown!\n")
+	return cooldown, Neurone{neurone.energy, neurone.inputs, cooldownLength, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 }
 
 // accumulate pulls energy off the dendrites and accumulates it within the neurone. When the neurone reaches
 // critical it fires into the axon (the web dendrites of adjacent neurones) and enters the cooldown state.
 func accumulate(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, newNeurone Neurone) {
-	de := <-neurone.deltaE
+	// Keep decaying the energy of the neurone when the dendrites are quiet.
+	in, _ := neurone.inputs.receiveWithin(animationStep)
+	i := in.Impulse
+	de := i.delta()
 	newEnergy := neurone.energy + de
 
 	// Neurone has reached threshold. Fire axon.
 	if newEnergy > 1.0 {
-		// Axon fires into the web dendrites of adjacent neurones.
-		for _, adjacent := range neurone.config.AdjacentNeurones {
-			buf := new(bytes.Buffer)
-			fmt.Fprintf(buf, "%s?e=%f", adjacent.Address, adjacent.Transfer)
-
-			address := buf.String()
-			go http.Get(address)
-			fmt.Printf("INFO: a[" + address + "]\n")
+		// Continue the cascade of the impulse that pushed the neurone over the threshold, or start a new one.
+		cascade := i.Cascade
+		if cascade == "" {
+			cascade = newCascadeID()
 		}
 
-		fmt.Printf("INFO: cooldown!\n")
-		return cooldown, Neurone{newEnergy, neurone.deltaE, cooldownLength, time.Now().UnixNano(), neurone.config}
+		neurone.energy = newEnergy
+		return fire(neurone, cascade)
 	}
 
 	// If the energy level jumps by a large amount, another neuron has fired. Run a power
@@ -188,7 +233,7 @@ func accumulate(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, new
 		fmt.Printf("INFO: powerup!\n")
 
 		powerupArduino(serialPort)
-		return powerup, Neurone{newEnergy, neurone.deltaE, powerupLength, time.Now().UnixNano(), neurone.config}
+		return powerup, Neurone{newEnergy, neurone.inputs, powerupLength, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 	}
 
 	// Slowly decay the energy of the neurone over time.
@@ -201,16 +246,14 @@ func accumulate(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, new
 	}
 
 	updateArduinoEnergy(newEnergy, serialPort)
-	return accumulate, Neurone{newEnergy, neurone.deltaE, 0.0, time.Now().UnixNano(), neurone.config}
+	return accumulate, Neurone{newEnergy, neurone.inputs, 0.0, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 }
 
 // calcDt calculates the change in seconds since an animation was started.
 func calcDt(neurone Neurone) float64 {
-	// Drain off and ignore changes in energy from the dendrites.
-	select {
-	case <-neurone.deltaE:
-	case <-time.After(250 * time.Millisecond):
-	}
+	// Changes in energy from the dendrites are left on the input bus, which drops them or keeps them for later
+	// depending on the input policy for the state.
+	time.Sleep(animationStep)
 
 	// Calculate how many seconds have elapsed since this cooldown state started.
 	return float64(time.Now().UnixNano()-neurone.start) / nanoToSeconds
@@ -222,7 +265,7 @@ func powerup(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, newNeu
 	dt := calcDt(neurone)
 
 	if dt >= neurone.duration {
-		return accumulate, Neurone{neurone.energy, neurone.deltaE, 0.0, time.Now().UnixNano(), neurone.config}
+		return accumulate, Neurone{neurone.energy, neurone.inputs, 0.0, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 	}
 
 	return powerup, neurone
@@ -238,28 +281,85 @@ func cooldown(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, newNe
 
 	// If the time elapsed is longer than the duration of the cooldown, enter the accumulate state.
 	if dt >= neurone.duration {
-		return accumulate, Neurone{0.0, neurone.deltaE, 0.0, time.Now().UnixNano(), neurone.config}
+		return accumulate, Neurone{0.0, neurone.inputs, 0.0, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 	}
 
 	cooldownArduino(newEnergy, serialPort)
-	return cooldown, Neurone{newEnergy, neurone.deltaE, neurone.duration, neurone.start, neurone.config}
+	return cooldown, Neurone{newEnergy, neurone.inputs, neurone.duration, neurone.start, neurone.config, neurone.peers, neurone.monitor}
+}
+
+// held keeps the neurone at a fixed energy while an operator overrides it, ignoring energy from the dendrites.
+func held(neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, newNeurone Neurone) {
+	calcDt(neurone)
+
+	updateArduinoEnergy(neurone.energy, serialPort)
+	return held, Neurone{neurone.energy, neurone.inputs, 0.0, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
 }
 
-// Axon listens to the dentrites on the deltaE channel, and embodies an artificial neurone. When the energy
+// applyOverride carries out a command from an operator that happens once, such as forcing the neurone to fire.
+func applyOverride(command string, neurone Neurone, serialPort io.ReadWriteCloser) (sF stateFn, newNeurone Neurone) {
+	switch command {
+	case fireOverride:
+		return fire(neurone, newCascadeID())
+
+	case powerupOverride:
+		fmt.Printf("INFO: powerup!\n")
+
+		powerupArduino(serialPort)
+		return powerup, Neurone{neurone.energy, neurone.inputs, powerupLength, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
+
+	case cooldownOverride:
+		fmt.Printf("INFO: cooldown!\n")
+		return cooldown, Neurone{neurone.energy, neurone.inputs, cooldownLength, time.Now().UnixNano(), neurone.config, neurone.peers, neurone.monitor}
+	}
+
+	return accumulate, neurone
+}
+
+// startingUp returns true while the neurone waits for the master or plays the startup sequence. Overrides are
+// held back until then, so every neurone plays the startup sequence.
+func startingUp(state stateFn) bool {
+	name := stateName(state)
+	return name == "wait" || name == "startup"
+}
+
+// Axon listens to the dentrites on the input bus, and embodies an artificial neurone. When the energy
 // of the neurone reaches a maximum, it fires into the axon (the web dendites of adjacent neurones).
-func axon(deltaE chan float32, config Configuration) {
-	// Find the device that represents the arduino serial connection.
-	c := &goserial.Config{Name: findArduino(), Baud: 9600}
-	s, _ := goserial.OpenPort(c)
+func axon(inputs *inputBus, live *liveConfiguration, peers *peerClient, power *powerLimiter,
+	ambient *ambientLight, monitor *monitor, overrides *overrides) {
 
-	// When connecting to an older revision arduino, you need to wait a little while it resets.
-	time.Sleep(1 * time.Second)
+	// Open the arduino and any other lighting outputs attached to the neurone.
+	config := live.get()
+	s := openLightingOutputs(config, power, ambient, monitor)
 
-	neurone := Neurone{-2.0, deltaE, waitLength, time.Now().UnixNano(), config}
+	neurone := Neurone{-2.0, inputs, waitLength, time.Now().UnixNano(), config, peers, monitor}
 	state := wait
+	holding := false
 
 	for {
+		// Pick up any changes to the configuration before each step.
+		neurone.config = live.get()
+
+		// Operators can override the neurone once it has started up, once released it goes back to accumulating
+		// energy.
+		command, pinned := "", (*override)(nil)
+		if !startingUp(state) {
+			command, pinned = overrides.next(time.Now())
+		}
+
+		if command != "" {
+			state, neurone = applyOverride(command, neurone, s)
+		} else if pinned != nil {
+			neurone.energy = pinned.Energy
+			state = held
+		} else if holding {
+			state = accumulate
+		}
+		holding = pinned != nil
+
 		state, neurone = state(neurone, s)
+		inputs.setState(stateName(state))
+		monitor.update(stateName(state), neurone.energy, time.Now())
 
 		fmt.Printf("INFO: e[%f]\n", neurone.energy)
 	}
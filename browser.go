@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const browserFrameRate = 30.0
+
+//go:embed browser/orb.html
+var orbPage []byte
+
+// browserLayout is the first message sent to the page, describing the orb it draws.
+type browserLayout struct {
+	Role   string
+	Pixels [][2]float64
+}
+
+// browserOrb streams the orb to web browsers, which draw it as a virtual orb. Artists can tune the look of the
+// orb without the hardware, or alongside it.
+type browserOrb struct {
+	mutex     sync.Mutex
+	server    *http.Server
+	listener  net.Listener
+	clients   map[*websocketConn]bool
+	layout    []byte
+	positions []pixelPosition
+}
+
+// newBrowserOutput creates a lighting output that serves a virtual orb to web browsers on the address nominated
+// in the configuration. Returns an error if the address can't be listened on or the pixel layout is invalid.
+func newBrowserOutput(config BrowserConfiguration, look *look) (io.ReadWriteCloser, error) {
+	b := &browserOrb{clients: map[*websocketConn]bool{}}
+
+	layout := browserLayout{Role: config.Role, Pixels: [][2]float64{}}
+	if config.Pixels > 0 {
+		positions, err := pixelLayout(config.Layout, config.Pixels)
+		if err != nil {
+			return nil, err
+		}
+
+		b.positions = positions
+		for _, p := range positions {
+			layout.Pixels = append(layout.Pixels, [2]float64{p.x, p.y})
+		}
+	}
+	b.layout, _ = json.Marshal(layout)
+
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	b.listener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.servePage)
+	mux.HandleFunc("/stream", b.serveStream)
+	b.server = &http.Server{Handler: mux, ReadTimeout: webReadTimeout, WriteTimeout: webWriteTimeout}
+
+	go func() {
+		err := b.server.Serve(listener)
+		if err != http.ErrServerClosed {
+			fmt.Printf("WARNING: Virtual orb on %s stopped: %s\n", config.Address, err)
+		}
+	}()
+
+	return newAnimatedOutput("browser "+config.Address, browserFrameRate, look, b.draw, b), nil
+}
+
+// Addr returns the address the virtual orb is served on.
+func (b *browserOrb) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// servePage handles requests for the page that draws the virtual orb.
+func (b *browserOrb) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(orbPage)
+}
+
+// serveStream sends the layout of the orb to the page, followed by each frame as it is drawn.
+func (b *browserOrb) serveStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebsocket(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := conn.writeText(b.layout); err != nil {
+		conn.Close()
+		return
+	}
+
+	b.mutex.Lock()
+	b.clients[conn] = true
+	b.mutex.Unlock()
+
+	// Drop the page once it goes away.
+	<-conn.discardMessages()
+	b.drop(conn)
+}
+
+// drop disconnects a page from the virtual orb.
+func (b *browserOrb) drop(conn *websocketConn) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.clients[conn] {
+		delete(b.clients, conn)
+		conn.Close()
+	}
+}
+
+// draw sends a frame holding the colour of the centre of the orb, followed by the colour of each pixel, to
+// every connected page.
+func (b *browserOrb) draw(animation *orbAnimation, now time.Time) error {
+	b.mutex.Lock()
+	clients := make([]*websocketConn, 0, len(b.clients))
+	for conn := range b.clients {
+		clients = append(clients, conn)
+	}
+	b.mutex.Unlock()
+
+	if len(clients) == 0 {
+		return nil
+	}
+
+	frame := append(animation.channels(now), renderPixels(animation, b.positions, now)...)
+	for _, conn := range clients {
+		if err := conn.writeBinary(frame); err != nil {
+			b.drop(conn)
+		}
+	}
+
+	return nil
+}
+
+// Close stops serving the virtual orb, disconnecting every page.
+func (b *browserOrb) Close() error {
+	err := b.server.Close()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for conn := range b.clients {
+		conn.Close()
+		delete(b.clients, conn)
+	}
+
+	return err
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readFrame reads a single unmasked frame of less than 126 bytes sent by the server.
+func readFrame(t *testing.T, conn net.Conn, reader *bufio.Reader) (byte, []byte) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatalf("unable to read frame: %s", err)
+	}
+
+	payload := make([]byte, header[1])
+	io.ReadFull(reader, payload)
+
+	return header[0] & 0x0f, payload
+}
+
+func TestBrowserOutput(t *testing.T) {
+	look, _ := newLook(defaultConfiguration().Lighting)
+	output, err := newBrowserOutput(BrowserConfiguration{"127.0.0.1:0", 4, "ring",
+		DeviceConfiguration{"test", 1.0, 0.0}}, look)
+	if err != nil {
+		t.Fatalf("unable to create browser output: %s", err)
+	}
+	defer output.Close()
+
+	address := output.(*animatedOutput).closer.(*browserOrb).Addr().String()
+
+	response, err := http.Get("http://" + address + "/")
+	if err != nil {
+		t.Fatalf("unable to fetch virtual orb page: %s", err)
+	}
+	page, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	if !strings.Contains(string(page), "Virtual orb") {
+		t.Errorf("virtual orb page not served.")
+	}
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("unable to connect to virtual orb: %s", err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "GET /stream HTTP/1.1\r\nHost: orb\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	if response, err := http.ReadResponse(reader, nil); err != nil || response.StatusCode != 101 {
+		t.Fatalf("virtual orb stream handshake failed.")
+	}
+
+	opcode, payload := readFrame(t, conn, reader)
+	var layout browserLayout
+	if opcode != opText || json.Unmarshal(payload, &layout) != nil || len(layout.Pixels) != 4 {
+		t.Fatalf("incorrect virtual orb layout %s", payload)
+	}
+
+	updateArduinoEnergy(1.0, output)
+
+	// Skip any frames that were drawn before the energy update arrived.
+	for i := 0; i < 30; i++ {
+		opcode, payload = readFrame(t, conn, reader)
+		if opcode != opBinary || len(payload) != 3*5 {
+			t.Fatalf("incorrect virtual orb frame %d %x", opcode, payload)
+		}
+
+		if payload[0] != 0 {
+			break
+		}
+	}
+
+	if string(payload[0:3]) != string(look.channels('e', 1.0)) {
+		t.Errorf("incorrect colour %x for full energy", payload[0:3])
+	}
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	caValidity          = 10 * 365 * 24 * time.Hour
+	certificateValidity = 5 * 365 * 24 * time.Hour
+)
+
+// certificateAuthority signs the certificates used by the neurones of an installation to authenticate each
+// other.
+type certificateAuthority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// newCertificateAuthority creates a self signed certificate authority with the nominated name.
+func newCertificateAuthority(name string) (*certificateAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := certificateTemplate(name, caValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificateAuthority{certificate, key}, nil
+}
+
+// loadCertificateAuthority reads a certificate authority previously saved to the nominated files.
+func loadCertificateAuthority(certificateFile string, keyFile string) (*certificateAuthority, error) {
+	certificateDER, err := readPEM(certificateFile, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(certificateDER)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := readPEM(keyFile, "EC PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParseECPrivateKey(keyDER)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificateAuthority{certificate, key}, nil
+}
+
+// save writes the certificate and key of the certificate authority to the nominated files.
+func (ca *certificateAuthority) save(certificateFile string, keyFile string) error {
+	keyDER, err := x509.MarshalECPrivateKey(ca.key)
+	if err != nil {
+		return err
+	}
+
+	err = writePEM(certificateFile, "CERTIFICATE", ca.certificate.Raw, 0644)
+	if err != nil {
+		return err
+	}
+
+	return writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600)
+}
+
+// issue creates a certificate and key for the nominated neurone, valid for both serving and sending requests
+// to other neurones at each of the nominated hosts (IP addresses or hostnames). Both are returned PEM encoded.
+func (ca *certificateAuthority) issue(name string, hosts []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template, err := certificateTemplate(name, certificateValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// certificateTemplate returns the settings shared by every certificate of the installation.
+func certificateTemplate(name string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"Gasworks"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// readPEM returns the contents of the first PEM block of the nominated type in the file.
+func readPEM(file string, blockType string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			return nil, errors.New("no " + blockType + " found in " + file)
+		}
+
+		if block.Type == blockType {
+			return block.Bytes, nil
+		}
+	}
+}
+
+// writePEM writes a single PEM block to the nominated file.
+func writePEM(file string, blockType string, der []byte, mode os.FileMode) error {
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), mode)
+}
+
+// validCertificateName returns an error if the certificate of a neurone with the nominated name can't be
+// written alongside the certificate authority, such as a neurone named "ca" overwriting its key.
+func validCertificateName(name string) error {
+	if name == "ca" || name == "ca-key" {
+		return errors.New("'" + name + "' is reserved for the certificate authority")
+	}
+
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return errors.New("'" + name + "' can't be used as a file name")
+	}
+
+	return nil
+}
+
+// certificates is the command line entry point for creating the certificate authority of an installation, and
+// the certificates of each neurone. The certificate authority is reused if it already exists in the directory.
+func certificates(args []string) {
+	flags := flag.NewFlagSet("certificates", flag.ExitOnError)
+	dir := flags.String("dir", "certs", "directory holding the certificate authority and neurone certificates")
+	caName := flags.String("ca-name", "Gasworks neurones", "name of the certificate authority")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Printf("usage: certificates [-dir path] [-ca-name name] name=host[,host...] ...\n")
+		os.Exit(2)
+	}
+
+	err := os.MkdirAll(*dir, 0755)
+	if err != nil {
+		fmt.Printf("ERROR: Unable to create %s: %s\n", *dir, err)
+		os.Exit(1)
+	}
+
+	caFile := filepath.Join(*dir, "ca.pem")
+	caKeyFile := filepath.Join(*dir, "ca-key.pem")
+
+	ca, err := loadCertificateAuthority(caFile, caKeyFile)
+	if os.IsNotExist(err) {
+		fmt.Printf("INFO: Creating certificate authority %s\n", caFile)
+		ca, err = newCertificateAuthority(*caName)
+		if err == nil {
+			err = ca.save(caFile, caKeyFile)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("ERROR: Unable to open certificate authority: %s\n", err)
+		os.Exit(1)
+	}
+
+	for _, arg := range flags.Args() {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			fmt.Printf("ERROR: Expected name=host[,host...], not '%s'\n", arg)
+			os.Exit(2)
+		}
+
+		if err := validCertificateName(parts[0]); err != nil {
+			fmt.Printf("ERROR: Invalid neurone name: %s\n", err)
+			os.Exit(2)
+		}
+
+		certificate, key, err := ca.issue(parts[0], strings.Split(parts[1], ","))
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(*dir, parts[0]+".pem"), certificate, 0644)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(*dir, parts[0]+"-key.pem"), key, 0600)
+		}
+
+		if err != nil {
+			fmt.Printf("ERROR: Unable to create certificate for %s: %s\n", parts[0], err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("INFO: Created %s.pem and %s-key.pem for %s\n", parts[0], parts[0], parts[1])
+	}
+}
@@ -21,6 +21,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
 	"os"
 )
 
@@ -29,7 +33,121 @@ type AdjacentNeurone struct {
 	Address  string
 }
 
+// DeviceConfiguration holds the settings shared by every lighting device attached to a neurone. The Role names
+// the part of the orb lit by the device (such as "inner" or "outer"), and the Scale multiplies the brightness of
+// the device. Watts is the estimated power drawn by the device at full brightness.
+type DeviceConfiguration struct {
+	Role  string
+	Scale float32
+	Watts float64
+}
+
+// ArduinoConfiguration describes the arduino connected to the neurone over serial. The Port is the path to the
+// serial device, leave it empty to search /dev for the arduino. When Pixels is zero, the arduino firmware plays
+// the animations itself. Otherwise the animations are rendered for the pixel Layout ("strip" or "ring") and
+// streamed to the arduino as frames at the nominated FrameRate. When the firmware plays the animations, updates
+// are coalesced and sent at no more than MaxUpdateRate commands per second (zero removes the limit). Every
+// command sent to the arduino is appended to the Recording file, unless it is left empty.
+//
+// A neurone with several arduinos lists them in the Arduinos of the configuration instead, each with its own
+// Port and Recording. Every other setting left out of these arduinos is taken from the Arduino, while a setting
+// given as zero (such as a MaxUpdateRate of 0) keeps its meaning for that arduino.
+type ArduinoConfiguration struct {
+	Port          string
+	Recording     string
+	Baud          int
+	Pixels        int
+	Layout        string
+	FrameRate     float64
+	MaxUpdateRate float64
+	DeviceConfiguration
+}
+
+// ArtNetConfiguration describes a DMX controller driven over Art-Net. Channel is the DMX address of the red
+// channel of the RGB fixture, with green and blue on the two channels that follow. Leave the Address empty to
+// disable the output.
+type ArtNetConfiguration struct {
+	Address  string
+	Universe uint16
+	Channel  int
+	DeviceConfiguration
+}
+
+// SACNConfiguration describes a lighting desk or DMX controller that receives the orb as an E1.31 (sACN)
+// universe. Packets are multicast unless an Address is nominated. Priority (0 to 200) and SourceName are shown
+// to the lighting desk when merging sources. Leave the Universe at zero to disable the output.
+type SACNConfiguration struct {
+	Address    string
+	Universe   uint16
+	Channel    int
+	Priority   uint8
+	SourceName string
+	DeviceConfiguration
+}
+
+// OPCConfiguration describes a strip of addressable LEDs driven by an Open Pixel Control server, such as a
+// Fadecandy. The Layout is either "strip" or "ring", and Channel zero sends frames to every channel on the
+// server. Leave the Address empty to disable the output.
+type OPCConfiguration struct {
+	Address string
+	Channel uint8
+	Pixels  int
+	Layout  string
+	DeviceConfiguration
+}
+
+// BrowserConfiguration describes a virtual orb drawn by a web browser, served on the nominated Address. When
+// Pixels is more than zero, the pixels are drawn around the orb in the Layout ("strip" or "ring") as well.
+type BrowserConfiguration struct {
+	Address string
+	Pixels  int
+	Layout  string
+	DeviceConfiguration
+}
+
+// TLSConfiguration nominates the PEM files used to encrypt and authenticate requests between neurones. Each
+// neurone presents its Certificate and Key, and only trusts neurones with a certificate signed by the CA. The
+// status, metrics, events, dashboard and operator endpoints can be reached without a certificate. Leave the CA
+// empty to disable TLS. The certificates can be created with the certificates command.
+type TLSConfiguration struct {
+	CA          string
+	Certificate string
+	Key         string
+}
+
+// CurveConfiguration describes the transfer curve from the energy of the neurone to the brightness of the orb.
+// The Type is "linear", "exponential" (using the Exponent) or "points", where the brightness is interpolated
+// between the Points, each an [energy, brightness] pair.
+type CurveConfiguration struct {
+	Type     string
+	Exponent float64
+	Points   [][2]float64
+}
+
+// LightingConfiguration describes the look of the orb. Palettes map the name of a state (accumulate, cooldown,
+// powerup or startup) to a gradient of [red, green, blue] colours, running from the colour at low energy to the
+// colour at full energy. A palette can be limited to devices with a particular role by prefixing the state with
+// the role, such as "outer.cooldown". Gamma correction is applied to each colour channel after the brightness
+// curve.
+type LightingConfiguration struct {
+	Palettes map[string][][3]float32
+	Gamma    float64
+	Curve    CurveConfiguration
+}
+
+// AmbientConfiguration describes how the brightness of the orb compensates for the ambient light seen by the
+// camera. The Curve maps the mean luminance of the scene (0.0 dark to 1.0 bright) to a scale for the brightness
+// of the lighting devices, such as {"Type": "points", "Points": [[0.0, 0.3], [0.5, 1.0]]}. Changes in luminance
+// are smoothed over the nominated number of seconds.
+type AmbientConfiguration struct {
+	Curve     CurveConfiguration
+	Smoothing float64
+}
+
 type Configuration struct {
+	// Name identifies the neurone to the rest of the cluster, it defaults to the hostname.
+	Name string
+
 	OpticalFlowScale  float64
 	MovementThreshold float64
 	DecayPerSecond    float64
@@ -37,13 +155,190 @@ type Configuration struct {
 	ListenAddress     string
 	AdjacentNeurones  []AdjacentNeurone
 
+	// The energy of impulses received by the web dendrite is clamped to +/- MaxImpulseEnergy.
+	MaxImpulseEnergy float32
+
 	MasterNeurone bool
 	AllNeurones   []AdjacentNeurone
+
+	// Requests between neurones are signed with the SharedSecret, and the web dendrite rejects any request that
+	// isn't. Leave it empty to accept unsigned requests. When AllowedPeers lists IP addresses or CIDR ranges,
+	// requests from anywhere else are rejected.
+	SharedSecret string
+	AllowedPeers []string
+	TLS          TLSConfiguration
+
+	// Operators present the OperatorToken to change the configuration of the running neurone. Leave it empty
+	// to disable the operator endpoints.
+	OperatorToken string
+
+	// The brightness of the lighting devices is scaled down to keep the power they draw within the budget (in
+	// watts) of the neurone. The master also keeps all neurones within the budget of the whole cluster. A
+	// budget of zero is unlimited.
+	PowerBudget        float64
+	ClusterPowerBudget float64
+
+	Arduino  ArduinoConfiguration
+	Arduinos []ArduinoConfiguration
+	ArtNet   ArtNetConfiguration
+	SACN     SACNConfiguration
+	OPC      OPCConfiguration
+	Browsers []BrowserConfiguration
+
+	Lighting LightingConfiguration
+	Ambient  AmbientConfiguration
+
+	// InputPolicies nominates what happens to inputs from the dendrites in each state of the neurone: "drop"
+	// them, "accumulate" them for later, or keep only the "latest".
+	InputPolicies map[string]string
+}
+
+// defaultConfiguration returns the configuration used for any settings missing from the configuration file.
+func defaultConfiguration() Configuration {
+	return Configuration{
+		Name:              defaultName(),
+		OpticalFlowScale:  300.0,
+		MovementThreshold: 1.0,
+		DecayPerSecond:    0.00217,
+		PowerUpThreshold:  0.25,
+		ListenAddress:     ":8080",
+		AdjacentNeurones:  []AdjacentNeurone{},
+		MaxImpulseEnergy:  2.0,
+		MasterNeurone:     false,
+		AllNeurones:       []AdjacentNeurone{},
+		SharedSecret:      "",
+		AllowedPeers:      []string{},
+		TLS:               TLSConfiguration{"", "", ""},
+		OperatorToken:     "",
+		Arduino:           ArduinoConfiguration{"", "", 9600, 0, "ring", 10.0, 10.0, DeviceConfiguration{"orb", 1.0, 0.0}},
+		Arduinos:          []ArduinoConfiguration{},
+		ArtNet:            ArtNetConfiguration{"", 0, 1, DeviceConfiguration{"artnet", 1.0, 0.0}},
+		SACN:              SACNConfiguration{"", 0, 1, 100, "Gasworks neurone", DeviceConfiguration{"sacn", 1.0, 0.0}},
+		OPC:               OPCConfiguration{"", 0, 64, "ring", DeviceConfiguration{"pixels", 1.0, 0.0}},
+		Browsers:          []BrowserConfiguration{},
+		Lighting:          LightingConfiguration{map[string][][3]float32{}, 1.0, CurveConfiguration{"linear", 0.0, nil}},
+		Ambient:           AmbientConfiguration{CurveConfiguration{"points", 0.0, [][2]float64{{0.0, 1.0}, {1.0, 1.0}}}, 30.0},
+		InputPolicies: map[string]string{"wait": accumulateInputs, "startup": dropInputs, "accumulate": accumulateInputs,
+			"powerup": dropInputs, "cooldown": dropInputs, "held": dropInputs},
+	}
+}
+
+// validateConfiguration returns an error if the configuration can't be used by a running neurone.
+func validateConfiguration(config Configuration) error {
+	if !finite(config.OpticalFlowScale) || config.OpticalFlowScale <= 0.0 {
+		return errors.New("OpticalFlowScale must be greater than zero")
+	}
+
+	if !finite(config.MovementThreshold) || config.MovementThreshold < 0.0 {
+		return errors.New("MovementThreshold can't be negative")
+	}
+
+	if !finite(config.DecayPerSecond) || config.DecayPerSecond < 0.0 {
+		return errors.New("DecayPerSecond can't be negative")
+	}
+
+	if !finite(float64(config.PowerUpThreshold)) || config.PowerUpThreshold <= 0.0 {
+		return errors.New("PowerUpThreshold must be greater than zero")
+	}
+
+	if !finite(float64(config.MaxImpulseEnergy)) || config.MaxImpulseEnergy <= 0.0 {
+		return errors.New("MaxImpulseEnergy must be greater than zero")
+	}
+
+	if config.ListenAddress == "" {
+		return errors.New("ListenAddress can't be empty")
+	}
+
+	if !finite(config.PowerBudget) || config.PowerBudget < 0.0 {
+		return errors.New("PowerBudget can't be negative")
+	}
+
+	if !finite(config.ClusterPowerBudget) || config.ClusterPowerBudget < 0.0 {
+		return errors.New("ClusterPowerBudget can't be negative")
+	}
+
+	if err := validateArduino(config.Arduino); err != nil {
+		return err
+	}
+
+	ports := map[string]bool{}
+	for _, arduino := range config.Arduinos {
+		if err := validateArduino(arduino); err != nil {
+			return err
+		}
+
+		if arduino.Port != "" && ports[arduino.Port] {
+			return fmt.Errorf("arduino port '%s' is used more than once", arduino.Port)
+		}
+		ports[arduino.Port] = true
+	}
+
+	addresses := map[string]bool{config.ListenAddress: true}
+	for _, browser := range config.Browsers {
+		if addresses[browser.Address] {
+			return fmt.Errorf("browser address '%s' is already in use", browser.Address)
+		}
+		addresses[browser.Address] = true
+	}
+
+	for _, neurones := range [][]AdjacentNeurone{config.AdjacentNeurones, config.AllNeurones} {
+		for _, adjacent := range neurones {
+			u, err := url.Parse(adjacent.Address)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid neurone address '%s'", adjacent.Address)
+			}
+
+			if !finite(float64(adjacent.Transfer)) {
+				return fmt.Errorf("invalid transfer for neurone '%s'", adjacent.Address)
+			}
+		}
+	}
+
+	for state, policy := range config.InputPolicies {
+		if policy != dropInputs && policy != accumulateInputs && policy != latestInputs {
+			return fmt.Errorf("unknown input policy '%s' for state '%s'", policy, state)
+		}
+	}
+
+	return nil
+}
+
+// validateArduino returns an error if the configuration of an arduino can't be used.
+func validateArduino(arduino ArduinoConfiguration) error {
+	if arduino.Baud <= 0 || arduino.Pixels < 0 {
+		return fmt.Errorf("invalid baud or pixels for arduino '%s'", arduino.Port)
+	}
+
+	if !finite(arduino.FrameRate) || arduino.FrameRate < 0.0 || !finite(arduino.MaxUpdateRate) ||
+		arduino.MaxUpdateRate < 0.0 {
+		return fmt.Errorf("rates can't be negative for arduino '%s'", arduino.Port)
+	}
+
+	if !finite(float64(arduino.Scale)) || arduino.Scale < 0.0 || !finite(arduino.Watts) || arduino.Watts < 0.0 {
+		return fmt.Errorf("scale and watts can't be negative for arduino '%s'", arduino.Port)
+	}
+
+	return nil
+}
+
+// finite returns true if the value is neither infinite nor NaN.
+func finite(value float64) bool {
+	return !math.IsInf(value, 0) && !math.IsNaN(value)
+}
+
+// defaultName returns the hostname of the neurone, or a generic name if the hostname is unavailable.
+func defaultName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "neurone"
+	}
+
+	return name
 }
 
 func parseConfiguration(configFile string) (configuration Configuration, err error) {
 	// Create a default configuration.
-	config := Configuration{300.0, 1.0, 0.00217, 0.25, ":8080", []AdjacentNeurone{}, false, []AdjacentNeurone{}}
+	config := defaultConfiguration()
 
 	// Open the configuration file.
 	file, err := os.Open(configFile)
@@ -51,12 +346,64 @@ func parseConfiguration(configFile string) (configuration Configuration, err err
 		return config, err
 	}
 
-	// Parse JSON in the configuration file.
+	// Parse JSON in the configuration file. The arduinos are kept aside until the base arduino is known.
+	settings := struct {
+		*Configuration
+		Arduinos []json.RawMessage
+	}{Configuration: &config}
+
 	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
+	err = decoder.Decode(&settings)
 	if err != nil {
 		return config, err
 	}
 
+	if settings.Arduinos != nil {
+		config.Arduinos = make([]ArduinoConfiguration, len(settings.Arduinos))
+	}
+
+	for i := range settings.Arduinos {
+		config.Arduinos[i], err = inheritArduino(settings.Arduinos[i], config.Arduino)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	for i := range config.Browsers {
+		config.Browsers[i] = inheritBrowser(config.Browsers[i], defaultBrowser)
+	}
+
 	return config, nil
 }
+
+// defaultBrowser holds the settings used for any left unset in the configuration of a virtual orb.
+var defaultBrowser = BrowserConfiguration{"", 0, "ring", DeviceConfiguration{"browser", 1.0, 0.0}}
+
+// inheritBrowser fills in any settings left unset in the configuration of a virtual orb from the base
+// configuration.
+func inheritBrowser(browser BrowserConfiguration, base BrowserConfiguration) BrowserConfiguration {
+	if browser.Layout == "" {
+		browser.Layout = base.Layout
+	}
+
+	if browser.Role == "" {
+		browser.Role = base.Role
+	}
+
+	if browser.Scale == 0.0 {
+		browser.Scale = base.Scale
+	}
+
+	return browser
+}
+
+// inheritArduino decodes the settings of an arduino over the base configuration, so it takes every setting it
+// leaves out from the base. The Port and Recording belong to each arduino, and are never inherited.
+func inheritArduino(settings json.RawMessage, base ArduinoConfiguration) (ArduinoConfiguration, error) {
+	arduino := base
+	arduino.Port = ""
+	arduino.Recording = ""
+
+	err := json.Unmarshal(settings, &arduino)
+	return arduino, err
+}
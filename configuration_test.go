@@ -73,4 +73,66 @@ func TestValidConfiguration(t *testing.T) {
 	if config.AdjacentNeurones[1].Address != "http://10.1.1.4:8080/" && config.AdjacentNeurones[1].Transfer != 0.2 {
 		t.Errorf("Did not correctly parse the first transfer neuron")
 	}
+
+	if err := validateConfiguration(config); err != nil {
+		t.Errorf("valid configuration failed validation: %s", err)
+	}
+}
+
+func TestInvalidConfiguration(t *testing.T) {
+	changes := map[string]func(config *Configuration){
+		"negative decay":          func(config *Configuration) { config.DecayPerSecond = -1.0 },
+		"negative power budget":   func(config *Configuration) { config.PowerBudget = -5.0 },
+		"negative update rate":    func(config *Configuration) { config.Arduino.MaxUpdateRate = -1.0 },
+		"negative arduino pixels": func(config *Configuration) { config.Arduinos[1].Pixels = -16 },
+		"clashing arduino ports": func(config *Configuration) {
+			config.Arduinos[1].Port = config.Arduinos[0].Port
+		},
+		"browser on the listen address": func(config *Configuration) {
+			config.Browsers = []BrowserConfiguration{{Address: config.ListenAddress}}
+		},
+	}
+
+	for name, change := range changes {
+		config, _ := parseConfiguration("testdata/multi-device-config.json")
+		if err := validateConfiguration(config); err != nil {
+			t.Fatalf("valid configuration failed validation: %s", err)
+		}
+
+		change(&config)
+		if validateConfiguration(config) == nil {
+			t.Errorf("configuration with %s passed validation.", name)
+		}
+	}
+}
+
+func TestMultipleArduinos(t *testing.T) {
+	config, err := parseConfiguration("testdata/multi-device-config.json")
+	if err != nil {
+		t.Errorf("returned error when parsing valid configuration file")
+	}
+
+	if len(config.Arduinos) != 2 {
+		t.Fatalf("Did not parse enough arduinos from the configuration")
+	}
+
+	if config.Arduinos[0].Role != "inner" || config.Arduinos[0].Scale != 0.5 {
+		t.Errorf("Did not correctly parse the role and scale of the inner arduino")
+	}
+
+	if config.Arduinos[0].Baud != 19200 || config.Arduinos[0].Layout != "ring" || config.Arduinos[0].Pixels != 16 {
+		t.Errorf("Inner arduino did not inherit unset settings")
+	}
+
+	if config.Arduinos[1].Pixels != 16 || config.Arduinos[1].Port != "/dev/ttyUSB1" {
+		t.Errorf("Outer arduino did not inherit the pixels, or lost its own port")
+	}
+
+	if config.Arduinos[0].MaxUpdateRate != 10.0 || config.Arduinos[1].MaxUpdateRate != 0.0 {
+		t.Errorf("Outer arduino could not remove the update rate limit")
+	}
+
+	if config.Arduinos[1].Baud != 9600 || config.Arduinos[1].Scale != 1.0 {
+		t.Errorf("Outer arduino did not keep its own settings")
+	}
 }
@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const clusterRequestTimeout = 400 * time.Millisecond
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// clusterNode is the status of a single neurone, as shown on the dashboard. Neurones that can't be reached
+// are reported offline with the Error that occurred. The master is reported at the address its neighbours use
+// for it.
+type clusterNode struct {
+	Address string
+	Self    bool
+	Online  bool
+	Error   string
+	Status  *neuroneStatus
+}
+
+// dashboardHandler serves the dashboard page embedded in the neurone.
+func dashboardHandler() http.Handler {
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+}
+
+// statusAddress returns the address of the status endpoint for the nominated neurone.
+func statusAddress(address string) (string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = "/status"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// fetchStatus asks the neurone at the nominated address for its status.
+func fetchStatus(peers *peerClient, address string) clusterNode {
+	node := clusterNode{Address: address}
+
+	address, err := statusAddress(address)
+	if err == nil {
+		var response *http.Response
+		response, err = peers.do("GET", address, nil, clusterRequestTimeout)
+		if err == nil {
+			if response.StatusCode != http.StatusOK {
+				err = fmt.Errorf("status request failed: %s", response.Status)
+			} else {
+				var status neuroneStatus
+				err = json.NewDecoder(response.Body).Decode(&status)
+				node.Status = &status
+			}
+			response.Body.Close()
+		}
+	}
+
+	if err != nil {
+		node.Error = err.Error()
+		node.Status = nil
+	} else {
+		node.Online = true
+	}
+
+	return node
+}
+
+// selfAddress returns the address the other neurones in the cluster use for the master, found amongst the
+// adjacent neurones in their configurations. The listen address of the master usually leaves out the host, so
+// addresses of the neurones that aren't already known are matched on the port. Falls back to the listen
+// address if no neurone fires into the master.
+func selfAddress(config Configuration, cluster []clusterNode) string {
+	fallback := "http://" + config.ListenAddress + "/"
+	host, port, err := net.SplitHostPort(config.ListenAddress)
+	if err != nil {
+		return fallback
+	}
+
+	if host == "" {
+		fallback = "http://localhost:" + port + "/"
+	}
+
+	known := map[string]bool{}
+	for _, neurone := range config.AllNeurones {
+		if u, err := url.Parse(neurone.Address); err == nil {
+			known[u.Host] = true
+		}
+	}
+
+	for _, node := range cluster {
+		if !node.Online {
+			continue
+		}
+
+		for _, adjacent := range node.Status.Configuration.AdjacentNeurones {
+			u, err := url.Parse(adjacent.Address)
+			if err != nil || known[u.Host] {
+				continue
+			}
+
+			adjacentPort := u.Port()
+			if adjacentPort == "" && u.Scheme == "https" {
+				adjacentPort = "443"
+			} else if adjacentPort == "" {
+				adjacentPort = "80"
+			}
+
+			if adjacentPort == port && (host == "" || u.Hostname() == host) {
+				return adjacent.Address
+			}
+		}
+	}
+
+	return fallback
+}
+
+// serveCluster handles requests from the dashboard for the status of every neurone in the cluster, starting
+// with the master itself.
+func (d *webDendrite) serveCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	config := d.live.get()
+	status := d.monitor.status(time.Now())
+	cluster := []clusterNode{{Address: config.ListenAddress, Self: true, Online: true, Status: &status}}
+
+	nodes := make([]chan clusterNode, len(config.AllNeurones))
+	for i, neurone := range config.AllNeurones {
+		nodes[i] = make(chan clusterNode, 1)
+		go func(address string, node chan clusterNode) {
+			node <- fetchStatus(d.client, address)
+		}(neurone.Address, nodes[i])
+	}
+
+	for _, node := range nodes {
+		cluster = append(cluster, <-node)
+	}
+	cluster[0].Address = selfAddress(config, cluster[1:])
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cluster)
+}
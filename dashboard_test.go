@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	peerConfig := defaultConfiguration()
+	peerConfig.Name = "left"
+	peerConfig.AdjacentNeurones = []AdjacentNeurone{{0.5, "http://127.0.0.1:1/"}, {0.5, "http://10.1.1.9:8080/"}}
+	peer := httptest.NewServer(http.HandlerFunc(newMonitor(peerConfig).serveStatus))
+	defer peer.Close()
+
+	config := defaultConfiguration()
+	config.MasterNeurone = true
+	config.AllNeurones = []AdjacentNeurone{{-1.0, peer.URL + "/"}, {-1.0, "http://127.0.0.1:1/"}}
+
+	client, _ := newPeerClient(config, nil)
+	live := newLiveConfiguration(config, "")
+	d, _ := newWebDendrite(newInputBus(live, nil), live, newPowerLimiter(0.0), client, newMonitor(config), newOverrides(nil))
+
+	w := httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("GET", "/dashboard/", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Gasworks neurones") {
+		t.Errorf("dashboard page not served.")
+	}
+
+	hues := w.Body.String()[strings.Index(w.Body.String(), "stateHues = {"):]
+	for _, state := range allStates {
+		if !strings.Contains(hues[:strings.Index(hues, "}")], state+": ") {
+			t.Errorf("no colour for the %s state on the dashboard.", state)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("GET", "/dashboard/cluster", nil))
+
+	var cluster []clusterNode
+	if err := json.NewDecoder(w.Body).Decode(&cluster); err != nil {
+		t.Fatalf("unable to decode cluster: %s", err)
+	}
+
+	if len(cluster) != 3 {
+		t.Fatalf("incorrect number of neurones %d in cluster", len(cluster))
+	}
+
+	if !cluster[0].Self || !cluster[0].Online {
+		t.Errorf("master missing from cluster.")
+	}
+
+	// The master listens on the default address, without a host, and is found by its port.
+	if cluster[0].Address != "http://10.1.1.9:8080/" {
+		t.Errorf("incorrect address %s for the master", cluster[0].Address)
+	}
+
+	if !cluster[1].Online || cluster[1].Status.Name != "left" {
+		t.Errorf("incorrect status for online neurone %+v", cluster[1])
+	}
+
+	if cluster[2].Online || cluster[2].Error == "" {
+		t.Errorf("unreachable neurone not reported offline.")
+	}
+
+	config.MasterNeurone = false
+	d, _ = newTestWebDendrite(config)
+
+	w = httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("GET", "/dashboard/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("dashboard served by a neurone that isn't the master.")
+	}
+}
@@ -32,6 +32,7 @@ import "C"
 import (
 	"fmt"
 	"math"
+	"time"
 	"unsafe"
 )
 
@@ -75,14 +76,16 @@ func calcDeltaEnergy(flow *C.IplImage, config *Configuration) float64 {
 	return deltaE
 }
 
-func dendriteCam(deltaE chan float32, config Configuration) {
+func dendriteCam(inputs *inputBus, live *liveConfiguration, ambient *ambientLight, monitor *monitor) {
 	camera := C.cvCaptureFromCAM(-1)
 
 	// Shutdown dendrite if no camera detected.
 	if camera == nil {
 		fmt.Printf("WARNING: No camera detected. Shutting down DendriteCam\n")
+		monitor.setCamera("not detected")
 		return
 	}
+	monitor.setCamera("running")
 
 	C.cvSetCaptureProperty(camera, C.CV_CAP_PROP_FRAME_WIDTH, 160)
 	C.cvSetCaptureProperty(camera, C.CV_CAP_PROP_FRAME_HEIGHT, 120)
@@ -108,8 +111,17 @@ func dendriteCam(deltaE chan float32, config Configuration) {
 		C.cvConvertImage(unsafe.Pointer(prev), unsafe.Pointer(prevG), 0)
 		C.cvConvertImage(unsafe.Pointer(next), unsafe.Pointer(nextG), 0)
 
+		// Publish the mean luminance of the scene, so the lighting can compensate for the ambient light.
+		luminance := C.cvAvg(unsafe.Pointer(nextG), nil)
+		ambient.update(float64(luminance.val[0])/255.0, time.Now())
+
+		start := time.Now()
 		C.cvCalcOpticalFlowFarneback(unsafe.Pointer(prevG), unsafe.Pointer(nextG), unsafe.Pointer(flow), 0.5, 2, 5, 2, 5, 1.1, 0)
-		deltaE <- float32(calcDeltaEnergy(flow, &config))
+		config := live.get()
+		i := newImpulse("camera", float32(calcDeltaEnergy(flow, &config)), "")
+		monitor.cameraFrame(time.Since(start), time.Now())
+		monitor.received("camera", i)
+		inputs.send("camera", i)
 
 		C.cvReleaseImage(&prev)
 		prev = next
@@ -20,20 +20,360 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
+	"time"
 )
 
-func dendriteWeb(deltaE chan float32, config Configuration) {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		i, err := strconv.ParseFloat(r.FormValue("e"), 32)
+const (
+	webReadTimeout     = 5 * time.Second
+	webWriteTimeout    = 10 * time.Second
+	webIdleTimeout     = 60 * time.Second
+	webShutdownTimeout = 5 * time.Second
+	maxRequestBody     = 64 << 10
+)
 
-		if err == nil {
-			fmt.Printf("Adjacent neurone fired %f! ***** \n", i)
-			deltaE <- float32(i)
+// webDendrite listens for adjacent neurones firing, along with the other requests made between neurones in the
+// cluster.
+type webDendrite struct {
+	inputs    *inputBus
+	live      *liveConfiguration
+	power     *powerLimiter
+	peers     *peerVerifier
+	client    *peerClient
+	monitor   *monitor
+	overrides *overrides
+	server    *http.Server
+	listener  net.Listener
+	errors    chan error
+}
+
+// newWebDendrite creates a web dendrite that sends the energy of adjacent neurones firing to the inputs, and
+// reports the status of the neurone kept by the monitor. Operators can change the configuration and apply
+// overrides. The master also serves the dashboard, using the client to collect the status of the cluster. The
+// dendrite doesn't listen for requests until it is started. Returns an error if the allowed peers or TLS
+// certificates in the configuration are invalid.
+func newWebDendrite(inputs *inputBus, live *liveConfiguration, power *powerLimiter, client *peerClient,
+	monitor *monitor, overrides *overrides) (*webDendrite, error) {
+
+	config := live.get()
+
+	peers, err := newPeerVerifier(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := serverTLSConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &webDendrite{inputs: inputs, live: live, power: power, peers: peers, client: client,
+		monitor: monitor, overrides: overrides, errors: make(chan error, 1)}
+	d.server = &http.Server{
+		Addr:         config.ListenAddress,
+		Handler:      d.routes(),
+		ReadTimeout:  webReadTimeout,
+		WriteTimeout: webWriteTimeout,
+		IdleTimeout:  webIdleTimeout,
+		TLSConfig:    tlsConfig,
+	}
+
+	return d, nil
+}
+
+// routes returns the router for every request understood by the web dendrite. Requests from other neurones
+// are only accepted from allowed peers, signed with the shared secret of the cluster. Changes to the power scale
+// are refused unless they are signed or come from an operator.
+func (d *webDendrite) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.peers.wrap(d.serveLegacyFire))
+	mux.HandleFunc("/v1/fire", d.peers.wrap(d.serveFire))
+	mux.HandleFunc("/power", d.peers.wrap(func(w http.ResponseWriter, r *http.Request) {
+		servePower(d.power, d.peers.signed() || operatorAuthorised(d.live, r), w, r)
+	}))
+	mux.HandleFunc("/status", d.monitor.serveStatus)
+	mux.HandleFunc("/metrics", d.monitor.serveMetrics)
+	mux.HandleFunc("/events", d.monitor.serveEvents)
+
+	mux.HandleFunc("/config", requireOperator(d.live, d.live.serveConfiguration))
+	mux.HandleFunc("/override", requireOperator(d.live, d.overrides.serveOverride))
+
+	if d.live.get().MasterNeurone {
+		mux.Handle("/dashboard/", dashboardHandler())
+		mux.HandleFunc("/dashboard/cluster", d.serveCluster)
+	}
+
+	return mux
+}
+
+// Start begins listening on the address nominated in the configuration, over TLS when it is enabled. Returns an
+// error if the address can't be listened on, any error while serving requests after that is delivered on Errors.
+func (d *webDendrite) Start() error {
+	listener, err := net.Listen("tcp", d.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	if d.server.TLSConfig != nil {
+		listener = tls.NewListener(listener, d.server.TLSConfig)
+	}
+	d.listener = listener
+
+	go func() {
+		err := d.server.Serve(listener)
+		if err != http.ErrServerClosed {
+			d.errors <- err
+		}
+		close(d.errors)
+	}()
+
+	return nil
+}
+
+// Addr returns the address the web dendrite is listening on once it has started.
+func (d *webDendrite) Addr() net.Addr {
+	return d.listener.Addr()
+}
+
+// Errors returns a channel that delivers the error that stopped the web dendrite serving requests. The channel
+// is closed once the dendrite has stopped.
+func (d *webDendrite) Errors() <-chan error {
+	return d.errors
+}
+
+// Stop shuts down the web dendrite, giving the requests in progress a little while to finish.
+func (d *webDendrite) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), webShutdownTimeout)
+	defer cancel()
+
+	return d.server.Shutdown(ctx)
+}
+
+// errorResponse is the body of the error returned when the web dendrite refuses a request.
+type errorResponse struct {
+	Error string
+}
+
+// writeError refuses a request with the nominated status, describing why in a JSON body.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{message})
+}
+
+// limitBody stops more than maxRequestBody bytes being read from the body of the request. Returns false, having
+// refused the request, if the body is declared to be larger than that.
+func limitBody(w http.ResponseWriter, r *http.Request) bool {
+	if r.ContentLength > maxRequestBody {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	return true
+}
+
+// serveFire handles the impulse sent by an adjacent neurone when it fires. The energy of the impulse is clamped
+// to the maximum in the configuration.
+func (d *webDendrite) serveFire(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if !limitBody(w, r) {
+		return
+	}
+
+	var i impulse
+	if err := json.NewDecoder(r.Body).Decode(&i); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid impulse: "+err.Error())
+		return
+	}
+
+	if err := i.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fmt.Printf("Adjacent neurone %s fired %s %f! ***** \n", i.Sender, i.Kind, i.Energy)
+	i = d.clamp(i)
+	d.monitor.received(d.source(r), i)
+	d.monitor.firingReceived(i)
+	d.inputs.send("web", i)
+}
+
+// source returns the name the impulses in a request are counted under, the host of the neurone in the cluster
+// that sent it, or "other" for anyone else.
+func (d *webDendrite) source(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	config := d.live.get()
+	for _, neurones := range [][]AdjacentNeurone{config.AdjacentNeurones, config.AllNeurones} {
+		for _, neurone := range neurones {
+			u, err := url.Parse(neurone.Address)
+			if err == nil && u.Hostname() == host {
+				return host
+			}
 		}
+	}
+
+	return otherSource
+}
+
+// serveLegacyFire handles the original request sent by an adjacent neurone when it fires, GET /?e=<energy>. It
+// is accepted until every neurone in the cluster sends impulses to /v1/fire.
+func (d *webDendrite) serveLegacyFire(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	e, err := strconv.ParseFloat(r.URL.Query().Get("e"), 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid energy")
+		return
+	}
+
+	if !finite(e) {
+		writeError(w, http.StatusBadRequest, "energy must be finite")
+		return
+	}
+
+	fmt.Printf("Adjacent neurone fired %f! ***** \n", e)
+	sender, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sender = r.RemoteAddr
+	}
+
+	fired := d.clamp(newImpulse(sender, float32(e), ""))
+	d.monitor.received(d.source(r), fired)
+	d.monitor.firingReceived(fired)
+	d.inputs.send("web", fired)
+}
+
+// clamp limits the energy of an impulse received by the web dendrite to the maximum in the configuration.
+func (d *webDendrite) clamp(i impulse) impulse {
+	max := d.live.get().MaxImpulseEnergy
+	if i.Energy > max {
+		fmt.Printf("WARNING: Clamped impulse from %s to %f\n", i.Sender, max)
+	}
+
+	return i.clamp(max)
+}
+
+// webRunner keeps a web dendrite running, moving it to the new address whenever the listen address in the
+// configuration changes.
+type webRunner struct {
+	mutex   sync.Mutex
+	live    *liveConfiguration
+	create  func() (*webDendrite, error)
+	current *webDendrite
+	errors  chan error
+}
+
+// startWebDendrite starts the web dendrite made by the create function, restarting it when the listen address
+// in the live configuration changes. Returns an error if the web dendrite can't be created or started.
+func startWebDendrite(live *liveConfiguration, create func() (*webDendrite, error)) (*webRunner, error) {
+	r := &webRunner{live: live, create: create, errors: make(chan error, 1)}
+
+	d, err := create()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.start(d); err != nil {
+		return nil, err
+	}
+
+	live.watch(func(config Configuration) {
+		// Restart in the background, the change may have been made by a request the old server is handling.
+		go r.move()
 	})
 
-	http.ListenAndServe(config.ListenAddress, nil)
+	return r, nil
+}
+
+// start begins listening with the nominated web dendrite, passing on any error that stops it serving requests.
+func (r *webRunner) start(d *webDendrite) error {
+	if err := d.Start(); err != nil {
+		return err
+	}
+	r.current = d
+
+	go func() {
+		for err := range d.Errors() {
+			r.errors <- err
+		}
+	}()
+
+	return nil
+}
+
+// move restarts the web dendrite on the listen address in the live configuration. If the new address can't be
+// listened on, the web dendrite goes back to the old address. The address is read once the mutex is held, so
+// the last of several quick changes always wins.
+func (r *webRunner) move() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	address := r.live.get().ListenAddress
+	previous := r.current.server.Addr
+	if address == previous {
+		return
+	}
+
+	fmt.Printf("INFO: Moving web dendrite from %s to %s\n", previous, address)
+	r.current.Stop()
+
+	d, err := r.create()
+	if err == nil {
+		d.server.Addr = address
+		err = r.start(d)
+	}
+
+	if err != nil {
+		fmt.Printf("ERROR: Unable to listen on %s, staying on %s: %s\n", address, previous, err)
+
+		d, err = r.create()
+		if err == nil {
+			d.server.Addr = previous
+			err = r.start(d)
+		}
+
+		if err != nil {
+			r.errors <- err
+		}
+	}
+}
+
+// Addr returns the address the web dendrite is currently listening on.
+func (r *webRunner) Addr() net.Addr {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.current.Addr()
+}
+
+// Errors returns a channel that delivers any error that stops the web dendrite serving requests.
+func (r *webRunner) Errors() <-chan error {
+	return r.errors
 }
@@ -0,0 +1,238 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestWebDendrite creates a web dendrite for the configuration, with its own input bus.
+func newTestWebDendrite(config Configuration) (*webDendrite, error) {
+	live := newLiveConfiguration(config, "")
+	monitor := newMonitor(config)
+	return newWebDendrite(newInputBus(live, monitor), live, newPowerLimiter(0.0), nil, monitor,
+		newOverrides(monitor))
+}
+
+func TestWebDendriteRoutes(t *testing.T) {
+	config := defaultConfiguration()
+	d, _ := newTestWebDendrite(config)
+
+	w := httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("GET", "/?e=0.5", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("legacy fire request failed with %d", w.Code)
+	}
+
+	if in, found := d.inputs.next(); !found {
+		t.Errorf("legacy fire request didn't reach the neurone.")
+	} else if in.Source != "web" || in.Impulse.delta() != 0.5 || in.Impulse.Kind != excitatoryImpulse {
+		t.Errorf("incorrect input %+v from legacy fire request", in)
+	}
+
+	w = httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("GET", "/anything?e=0.5", nil))
+	if w.Code != http.StatusNotFound || d.inputs.pending() != 0 {
+		t.Errorf("energy was accepted on an unknown path.")
+	}
+
+	w = httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("GET", "/?e=lots", nil))
+	if w.Code != http.StatusBadRequest || d.inputs.pending() != 0 {
+		t.Errorf("invalid energy was accepted.")
+	}
+}
+
+func TestWebDendriteSources(t *testing.T) {
+	config := defaultConfiguration()
+	config.AllNeurones = []AdjacentNeurone{{0.0, "http://192.0.2.1:8080/"}}
+	d, _ := newTestWebDendrite(config)
+
+	for i, address := range []string{"192.0.2.1:1234", "10.1.1.9:1234", "10.1.1.10:1234"} {
+		body := fmt.Sprintf(`{"Sender": "sender %d", "Energy": 0.1, "Kind": "excitatory"}`, i)
+		r := httptest.NewRequest("POST", "/v1/fire", strings.NewReader(body))
+		r.RemoteAddr = address
+		d.routes().ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	inputs := d.monitor.status(time.Now()).Inputs
+	if len(inputs) != 2 || inputs["192.0.2.1"] != 1 || inputs[otherSource] != 2 {
+		t.Errorf("impulses not counted by the neurone that sent them %v", inputs)
+	}
+}
+
+func TestWebDendriteStartStop(t *testing.T) {
+	config := defaultConfiguration()
+	config.ListenAddress = "127.0.0.1:0"
+
+	d, _ := newTestWebDendrite(config)
+	if err := d.Start(); err != nil {
+		t.Fatalf("unable to start web dendrite: %s", err)
+	}
+
+	response, err := http.Get("http://" + d.Addr().String() + "/power")
+	if err != nil {
+		t.Fatalf("unable to reach web dendrite: %s", err)
+	}
+	response.Body.Close()
+
+	// A second dendrite on the same address should report the listen error.
+	config.ListenAddress = d.Addr().String()
+	other, _ := newTestWebDendrite(config)
+	if other.Start() == nil {
+		t.Errorf("listening on an address in use didn't return an error.")
+	}
+
+	if err := d.Stop(); err != nil {
+		t.Errorf("unable to stop web dendrite: %s", err)
+	}
+
+	select {
+	case err, ok := <-d.Errors():
+		if ok {
+			t.Errorf("web dendrite stopped with an error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("web dendrite didn't stop.")
+	}
+}
+
+func TestWebDendriteFire(t *testing.T) {
+	config := defaultConfiguration()
+	config.ListenAddress = "127.0.0.1:0"
+
+	d, _ := newTestWebDendrite(config)
+	if err := d.Start(); err != nil {
+		t.Fatalf("unable to start web dendrite: %s", err)
+	}
+	defer d.Stop()
+
+	peers, _ := newPeerClient(config, nil)
+	err := peers.fire("http://"+d.Addr().String()+"/", newImpulse("left", -0.25, "abc"))
+	if err != nil {
+		t.Fatalf("unable to fire into web dendrite: %s", err)
+	}
+
+	in, _ := d.inputs.receiveWithin(time.Second)
+	i := in.Impulse
+	if i.Sender != "left" || i.Kind != inhibitoryImpulse || i.Cascade != "abc" || i.delta() != -0.25 {
+		t.Errorf("incorrect impulse %+v received", i)
+	}
+
+	requests := []struct {
+		method string
+		body   string
+		status int
+	}{
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", "{", http.StatusBadRequest},
+		{"POST", `{"Energy": 0.5, "Kind": "tickle"}`, http.StatusBadRequest},
+		{"POST", `{"Energy": -0.5, "Kind": "excitatory"}`, http.StatusBadRequest},
+	}
+
+	for _, r := range requests {
+		w := httptest.NewRecorder()
+		d.routes().ServeHTTP(w, httptest.NewRequest(r.method, "/v1/fire", strings.NewReader(r.body)))
+		if w.Code != r.status {
+			t.Errorf("%s %s returned %d instead of %d", r.method, r.body, w.Code, r.status)
+		}
+	}
+
+	if d.inputs.pending() != 0 {
+		t.Errorf("invalid impulse reached the neurone.")
+	}
+}
+
+func TestWebDendriteValidation(t *testing.T) {
+	config := defaultConfiguration()
+	d, _ := newTestWebDendrite(config)
+
+	requests := []struct {
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"GET", "/?e=NaN", "", http.StatusBadRequest},
+		{"GET", "/?e=-Inf", "", http.StatusBadRequest},
+		{"GET", "/?e=1e999", "", http.StatusBadRequest},
+		{"POST", "/?e=0.5", "", http.StatusMethodNotAllowed},
+		{"POST", "/v1/fire", `{"Energy": 1e999, "Kind": "excitatory"}`, http.StatusBadRequest},
+		{"POST", "/power", `{"Scale": 0}`, http.StatusForbidden},
+		{"DELETE", "/power", "", http.StatusMethodNotAllowed},
+		{"POST", "/status", "", http.StatusMethodNotAllowed},
+		{"POST", "/metrics", "", http.StatusMethodNotAllowed},
+		{"GET", "/events", "", http.StatusBadRequest},
+		{"GET", "/config", "", http.StatusForbidden},
+		{"POST", "/override", `{"Command": "fire"}`, http.StatusForbidden},
+	}
+
+	for _, r := range requests {
+		w := httptest.NewRecorder()
+		d.routes().ServeHTTP(w, httptest.NewRequest(r.method, r.target, strings.NewReader(r.body)))
+
+		var refused errorResponse
+		err := json.NewDecoder(w.Body).Decode(&refused)
+		if w.Code != r.status || err != nil || refused.Error == "" {
+			t.Errorf("%s %s returned %d without a JSON error, expected %d", r.method, r.target, w.Code, r.status)
+		}
+	}
+
+	if d.inputs.pending() != 0 {
+		t.Errorf("invalid energy reached the neurone.")
+	}
+
+	// Bodies larger than the limit are refused, whether or not their size is declared.
+	large := `{"Sender": "` + strings.Repeat("x", maxRequestBody) + `", "Energy": 0.5, "Kind": "excitatory"}`
+	w := httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("POST", "/v1/fire", strings.NewReader(large)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large impulse returned %d", w.Code)
+	}
+
+	r := httptest.NewRequest("POST", "/v1/fire", strings.NewReader(large))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	d.routes().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || d.inputs.pending() != 0 {
+		t.Errorf("large impulse of unknown size returned %d", w.Code)
+	}
+
+	for _, target := range []string{"/?e=1e30", "/?e=-1e30"} {
+		w := httptest.NewRecorder()
+		d.routes().ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if in, _ := d.inputs.receiveWithin(time.Second); w.Code != http.StatusOK || in.Impulse.Energy != config.MaxImpulseEnergy {
+			t.Errorf("energy of %s not clamped to the maximum.", target)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	body := `{"Sender": "left", "Energy": 50, "Kind": "excitatory"}`
+	d.routes().ServeHTTP(w, httptest.NewRequest("POST", "/v1/fire", strings.NewReader(body)))
+	if in, _ := d.inputs.receiveWithin(time.Second); w.Code != http.StatusOK || in.Impulse.Energy != config.MaxImpulseEnergy {
+		t.Errorf("energy of impulse not clamped to the maximum.")
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stateEvent    = "state"
+	energyEvent   = "energy"
+	firedEvent    = "fired"
+	receivedEvent = "received"
+
+	subscriberBuffer = 64
+	defaultEventRate = 10.0
+)
+
+var allEvents = []string{stateEvent, energyEvent, firedEvent, receivedEvent}
+
+// event describes something that happened to the neurone, as sent to the clients of the event stream. Firings
+// carry the impulse, along with the Address of the neurone fired into and any Error delivering it.
+type event struct {
+	Type    string
+	Time    time.Time
+	Neurone string
+	State   string
+	Energy  float32
+	Impulse *impulse
+	Address string
+	Error   string
+}
+
+// subscriber receives the events of the nominated types from the monitor.
+type subscriber struct {
+	events chan event
+	types  map[string]bool
+}
+
+// subscribe returns a subscriber for the nominated event types. Events are dropped if the subscriber falls too
+// far behind.
+func (m *monitor) subscribe(types []string) *subscriber {
+	s := &subscriber{events: make(chan event, subscriberBuffer), types: map[string]bool{}}
+	for _, t := range types {
+		s.types[t] = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.subscribers[s] = true
+	return s
+}
+
+// unsubscribe stops sending events to the subscriber.
+func (m *monitor) unsubscribe(s *subscriber) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.subscribers, s)
+}
+
+// publish sends the event to every subscriber of its type, the monitor must be locked by the caller.
+func (m *monitor) publish(e event) {
+	e.Neurone = m.config.Name
+
+	for s := range m.subscribers {
+		if !s.types[e.Type] {
+			continue
+		}
+
+		select {
+		case s.events <- e:
+		default:
+		}
+	}
+}
+
+// firingReceived publishes the impulse received from another neurone.
+func (m *monitor) firingReceived(i impulse) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.publish(event{Type: receivedEvent, Time: time.Now(), State: m.state, Energy: m.energy, Impulse: &i})
+}
+
+// serveEvents streams the events of the neurone to a WebSocket client as JSON messages. The client picks the
+// events it wants with the events parameter (such as "?events=state,fired"), and the number of energy updates
+// sent each second with the rate parameter. Every other event is always sent.
+func (m *monitor) serveEvents(w http.ResponseWriter, r *http.Request) {
+	types := allEvents
+	if r.FormValue("events") != "" {
+		types = strings.Split(r.FormValue("events"), ",")
+	}
+
+	rate := defaultEventRate
+	if r.FormValue("rate") != "" {
+		var err error
+		rate, err = strconv.ParseFloat(r.FormValue("rate"), 64)
+		if err != nil || rate <= 0.0 {
+			writeError(w, http.StatusBadRequest, "rate must be a positive number of events per second")
+			return
+		}
+	}
+
+	conn, err := upgradeWebsocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	s := m.subscribe(types)
+	defer m.unsubscribe(s)
+
+	closed := conn.discardMessages()
+	interval := time.Duration(float64(time.Second) / rate)
+	var lastEnergy time.Time
+
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-s.events:
+			if e.Type == energyEvent {
+				if e.Time.Sub(lastEnergy) < interval {
+					continue
+				}
+				lastEnergy = e.Time
+			}
+
+			message, _ := json.Marshal(e)
+			if err := conn.writeText(message); err != nil {
+				return
+			}
+		}
+	}
+}
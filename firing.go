@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	excitatoryImpulse = "excitatory"
+	inhibitoryImpulse = "inhibitory"
+	controlImpulse    = "control"
+
+	fireRequestTimeout = 2 * time.Second
+)
+
+// impulse is a change in energy delivered to the neurone by one of its dendrites. It is also the body of the
+// request sent to adjacent neurones when the neurone fires, POST /v1/fire. The Energy is always positive, the
+// Kind determines if it excites or inhibits the neurone. Control impulses are sent by the master to start the
+// cluster, and carry no energy. Every firing caused by the same burst of energy shares a Cascade identifier.
+type impulse struct {
+	Sender    string
+	Energy    float32
+	Kind      string
+	Timestamp time.Time
+	Cascade   string
+}
+
+// newImpulse creates an impulse from a signed change in energy, excitatory when positive and inhibitory when
+// negative.
+func newImpulse(sender string, energy float32, cascade string) impulse {
+	kind := excitatoryImpulse
+	if energy < 0.0 {
+		kind = inhibitoryImpulse
+		energy = -energy
+	}
+
+	return impulse{sender, energy, kind, time.Now(), cascade}
+}
+
+// delta returns the signed change in energy of the neurone caused by the impulse.
+func (i impulse) delta() float32 {
+	switch i.Kind {
+	case inhibitoryImpulse:
+		return -i.Energy
+	case controlImpulse:
+		return 0.0
+	}
+
+	return i.Energy
+}
+
+// validate returns an error if the impulse received from another neurone is malformed.
+func (i impulse) validate() error {
+	if i.Kind != excitatoryImpulse && i.Kind != inhibitoryImpulse && i.Kind != controlImpulse {
+		return fmt.Errorf("unknown impulse kind '%s'", i.Kind)
+	}
+
+	if !finite(float64(i.Energy)) {
+		return errors.New("impulse energy must be finite")
+	}
+
+	if i.Energy < 0.0 {
+		return errors.New("impulse energy must be positive")
+	}
+
+	return nil
+}
+
+// clamp limits the energy of the impulse to the nominated maximum.
+func (i impulse) clamp(max float32) impulse {
+	if i.Energy > max {
+		i.Energy = max
+	}
+
+	return i
+}
+
+// newCascadeID returns a random identifier for a cascade of firings started by this neurone.
+func newCascadeID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// fireAddress returns the address of the firing endpoint for the nominated neurone.
+func fireAddress(address string) (string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = "/v1/fire"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// fire sends the impulse to the neurone at the nominated address, recording how long it took to deliver.
+// Returns an error if the neurone can't be reached or refuses the impulse.
+func (c *peerClient) fire(address string, i impulse) error {
+	start := time.Now()
+	err := c.sendImpulse(address, i)
+	c.monitor.delivered(address, i, time.Since(start), err)
+
+	return err
+}
+
+// sendImpulse posts the impulse to the firing endpoint of the neurone at the nominated address.
+func (c *peerClient) sendImpulse(address string, i impulse) error {
+	address, err := fireAddress(address)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.do("POST", address, body, fireRequestTimeout)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var refused errorResponse
+		json.NewDecoder(response.Body).Decode(&refused)
+		return fmt.Errorf("%s refused impulse: %s %s", address, response.Status, refused.Error)
+	}
+
+	return nil
+}
+
+// fireInBackground sends the impulse to the neurone at the nominated address without waiting for it to
+// respond, reporting the neurones that can't be reached.
+func (c *peerClient) fireInBackground(address string, i impulse) {
+	go func() {
+		if err := c.fire(address, i); err != nil {
+			fmt.Printf("WARNING: Unable to fire into %s: %s\n", address, err)
+		}
+	}()
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	dropInputs       = "drop"
+	accumulateInputs = "accumulate"
+	latestInputs     = "latest"
+
+	inputBusLength = 256
+)
+
+// input is an impulse delivered to the neurone, tagged with the dendrite it arrived on and when it arrived.
+type input struct {
+	Source   string
+	Received time.Time
+	Impulse  impulse
+}
+
+// inputBus carries the inputs of the dendrites to the axon without ever blocking the dendrites. What happens to
+// an input depends on the policy for the state of the neurone when it arrives. Inputs are dropped, kept in
+// order for the axon to accumulate later, or replace every other input waiting for the axon (latest wins).
+type inputBus struct {
+	mutex   sync.Mutex
+	live    *liveConfiguration
+	monitor *monitor
+	state   string
+	queue   []input
+	ready   chan bool
+}
+
+// newInputBus creates an input bus for a neurone running with the input policies in the live configuration.
+func newInputBus(live *liveConfiguration, monitor *monitor) *inputBus {
+	return &inputBus{live: live, monitor: monitor, state: "wait", ready: make(chan bool, 1)}
+}
+
+// send delivers the impulse from the nominated source to the axon. It never blocks, inputs that can't be kept
+// are dropped. When the bus is full the oldest input is dropped to make room.
+func (b *inputBus) send(source string, i impulse) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.live.get().InputPolicies[b.state] {
+	case dropInputs:
+		b.monitor.inputDropped(source)
+		return
+
+	case latestInputs:
+		for _, dropped := range b.queue {
+			b.monitor.inputDropped(dropped.Source)
+		}
+		b.queue = nil
+
+	default:
+		if len(b.queue) >= inputBusLength {
+			b.monitor.inputDropped(b.queue[0].Source)
+			b.queue = b.queue[1:]
+		}
+	}
+
+	b.queue = append(b.queue, input{source, time.Now(), i})
+
+	select {
+	case b.ready <- true:
+	default:
+	}
+}
+
+// setState changes the state of the neurone, selecting the policy for the inputs that arrive from now on.
+func (b *inputBus) setState(state string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.state = state
+}
+
+// next removes the oldest input from the bus. Returns false if the bus is empty.
+func (b *inputBus) next() (input, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.queue) == 0 {
+		return input{}, false
+	}
+
+	i := b.queue[0]
+	b.queue = b.queue[1:]
+	return i, true
+}
+
+// pending returns the number of inputs waiting for the axon.
+func (b *inputBus) pending() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.queue)
+}
+
+// receiveWithin waits up to the nominated timeout for the next input to arrive on the bus. Returns false if no
+// input arrived in time.
+func (b *inputBus) receiveWithin(timeout time.Duration) (input, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if i, found := b.next(); found {
+			return i, true
+		}
+
+		select {
+		case <-b.ready:
+		case <-timer.C:
+			return input{}, false
+		}
+	}
+}
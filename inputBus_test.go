@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInputPolicies(t *testing.T) {
+	config := defaultConfiguration()
+	config.InputPolicies["powerup"] = latestInputs
+	m := newMonitor(config)
+	b := newInputBus(newLiveConfiguration(config, ""), m)
+
+	b.setState("accumulate")
+	b.send("camera", newImpulse("camera", 0.1, ""))
+	b.send("web", newImpulse("left", 0.2, ""))
+
+	b.setState("cooldown")
+	b.send("camera", newImpulse("camera", 0.3, ""))
+
+	for _, energy := range []float32{0.1, 0.2} {
+		if in, found := b.next(); !found || in.Impulse.Energy != energy || in.Received.IsZero() {
+			t.Errorf("input of %f not kept in order for later.", energy)
+		}
+	}
+
+	if b.pending() != 0 {
+		t.Errorf("input kept while the policy was to drop it.")
+	}
+
+	b.setState("powerup")
+	b.send("camera", newImpulse("camera", 0.4, ""))
+	b.send("web", newImpulse("left", 0.5, ""))
+
+	if in, _ := b.next(); b.pending() != 0 || in.Source != "web" || in.Impulse.Energy != 0.5 {
+		t.Errorf("latest input didn't replace the others.")
+	}
+
+	var metrics bytes.Buffer
+	m.writeMetrics(&metrics)
+	if !strings.Contains(metrics.String(), `neurone_dropped_inputs_total{source="camera"} 2`) {
+		t.Errorf("dropped inputs not counted:\n%s", metrics.String())
+	}
+}
+
+func TestInputBusNeverBlocks(t *testing.T) {
+	config := defaultConfiguration()
+	b := newInputBus(newLiveConfiguration(config, ""), nil)
+	b.setState("accumulate")
+
+	for i := 0; i <= inputBusLength; i++ {
+		b.send("camera", newImpulse("camera", float32(i), ""))
+	}
+
+	if in, _ := b.receiveWithin(time.Millisecond); b.pending() != inputBusLength-1 || in.Impulse.Energy != 1.0 {
+		t.Errorf("oldest input not dropped to make room.")
+	}
+
+	for b.pending() > 0 {
+		b.receiveWithin(time.Second)
+	}
+
+	if _, found := b.receiveWithin(10 * time.Millisecond); found {
+		t.Errorf("input received from an empty bus.")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.send("web", newImpulse("left", 0.5, ""))
+	}()
+
+	if in, _ := b.receiveWithin(time.Second); in.Source != "web" {
+		t.Errorf("waiting for an input didn't receive it.")
+	}
+}
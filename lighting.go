@@ -0,0 +1,428 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/huin/goserial"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	commandLength     = 5
+	frameHeaderLength = 3
+	dmxChannels       = 512
+	dmxFrameRate      = 30.0
+
+	sendWarningInterval = 10 * time.Second
+)
+
+// sendWarnings reports the failed sends to a lighting output. Only the first failure in each interval is
+// reported, so an output that has gone away doesn't flood the log.
+type sendWarnings struct {
+	output string
+	last   time.Time
+	missed int
+}
+
+// check reports the error from a send to the output, unless a failure has already been reported within the
+// interval.
+func (w *sendWarnings) check(err error, now time.Time) {
+	if err == nil {
+		return
+	}
+
+	if !w.last.IsZero() && now.Sub(w.last) < sendWarningInterval {
+		w.missed++
+		return
+	}
+
+	if w.missed > 0 {
+		fmt.Printf("WARNING: Unable to send to %s (%d more failures since the last warning): %s\n", w.output,
+			w.missed, err)
+	} else {
+		fmt.Printf("WARNING: Unable to send to %s: %s\n", w.output, err)
+	}
+	w.last = now
+	w.missed = 0
+}
+
+// arduinoName returns the name of the arduino reported by the monitor and in warnings.
+func arduinoName(config ArduinoConfiguration) string {
+	if config.Port != "" {
+		return "arduino " + config.Port
+	}
+
+	return "arduino " + config.Role
+}
+
+// lightingOutputs fans the commands sent to the arduino out to every lighting output attached to the neurone.
+type lightingOutputs []io.ReadWriteCloser
+
+func (l lightingOutputs) Read(p []byte) (n int, err error) {
+	return 0, io.EOF
+}
+
+func (l lightingOutputs) Write(p []byte) (n int, err error) {
+	for _, output := range l {
+		_, e := output.Write(p)
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return len(p), err
+}
+
+func (l lightingOutputs) Close() (err error) {
+	for _, output := range l {
+		e := output.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// openLightingOutputs connects to the arduinos and every other lighting output nominated in the configuration.
+// Outputs that can't be opened are skipped with a warning, so the neurone still runs without any lights. The
+// status of each output is recorded by the monitor.
+func openLightingOutputs(config Configuration, power *powerLimiter, ambient *ambientLight,
+	monitor *monitor) io.ReadWriteCloser {
+
+	outputs := lightingOutputs{}
+
+	look, err := newLook(config.Lighting)
+	if err != nil {
+		fmt.Printf("WARNING: Invalid lighting configuration, using the default look: %s\n", err)
+		look, _ = newLook(defaultConfiguration().Lighting)
+	}
+	look.power = power
+	look.ambient = ambient
+
+	// A neurone with several arduinos lists them all, otherwise there is just the one.
+	arduinos := config.Arduinos
+	if len(arduinos) == 0 {
+		arduinos = []ArduinoConfiguration{config.Arduino}
+	}
+
+	for _, arduino := range arduinos {
+		name := arduinoName(arduino)
+		a, err := openArduino(arduino, look.forDevice(arduino.DeviceConfiguration), monitor)
+		if err != nil {
+			fmt.Printf("WARNING: Unable to open %s arduino: %s\n", arduino.Role, err)
+		} else {
+			outputs = append(outputs, a)
+		}
+		monitor.setOutput(name, outputStatus(err))
+	}
+
+	// When connecting to an older revision arduino, you need to wait a little while it resets.
+	if len(outputs) > 0 {
+		time.Sleep(1 * time.Second)
+	}
+
+	if config.ArtNet.Address != "" {
+		a, err := newArtNetOutput(config.ArtNet, look.forDevice(config.ArtNet.DeviceConfiguration))
+		if err != nil {
+			fmt.Printf("WARNING: Unable to open Art-Net output %s: %s\n", config.ArtNet.Address, err)
+		} else {
+			outputs = append(outputs, a)
+		}
+		monitor.setOutput("artnet "+config.ArtNet.Address, outputStatus(err))
+	}
+
+	if config.SACN.Universe != 0 {
+		a, err := newSACNOutput(config.SACN, look.forDevice(config.SACN.DeviceConfiguration))
+		if err != nil {
+			fmt.Printf("WARNING: Unable to open sACN output %d: %s\n", config.SACN.Universe, err)
+		} else {
+			outputs = append(outputs, a)
+		}
+		monitor.setOutput(fmt.Sprintf("sacn %d", config.SACN.Universe), outputStatus(err))
+	}
+
+	if config.OPC.Address != "" {
+		o, err := newOPCOutput(config.OPC, look.forDevice(config.OPC.DeviceConfiguration))
+		if err != nil {
+			fmt.Printf("WARNING: Unable to open OPC output %s: %s\n", config.OPC.Address, err)
+		} else {
+			outputs = append(outputs, o)
+		}
+		monitor.setOutput("opc "+config.OPC.Address, outputStatus(err))
+	}
+
+	for _, browser := range config.Browsers {
+		b, err := newBrowserOutput(browser, look.forDevice(browser.DeviceConfiguration))
+		if err != nil {
+			fmt.Printf("WARNING: Unable to serve virtual orb on %s: %s\n", browser.Address, err)
+		} else {
+			fmt.Printf("INFO: Virtual orb served on %s\n", browser.Address)
+			outputs = append(outputs, b)
+		}
+		monitor.setOutput("browser "+browser.Address, outputStatus(err))
+	}
+
+	return outputs
+}
+
+// outputStatus describes the outcome of opening a lighting output.
+func outputStatus(err error) string {
+	if err != nil {
+		return "unavailable: " + err.Error()
+	}
+
+	return "connected"
+}
+
+// openArduino connects to the arduino described by the configuration. The serial port is searched for when the
+// configuration doesn't nominate one, and failed writes to it are counted by the monitor. Returns an error if the
+// arduino can't be opened.
+func openArduino(config ArduinoConfiguration, look *look, monitor *monitor) (io.ReadWriteCloser, error) {
+	port := config.Port
+	if port == "" {
+		port = findArduino()
+	}
+
+	s, err := goserial.OpenPort(&goserial.Config{Name: port, Baud: config.Baud})
+	if err != nil {
+		return nil, err
+	}
+	s = monitoredPort{s, monitor}
+
+	if config.Recording != "" {
+		flags := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+		recording, err := os.OpenFile(config.Recording, flags, 0644)
+		if err != nil {
+			fmt.Printf("WARNING: Unable to record arduino commands to %s: %s\n", config.Recording, err)
+		} else {
+			s = newSerialRecorder(s, recording)
+		}
+	}
+
+	a, err := newArduinoOutput(s, config, look)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// legacyArduino forwards commands to arduino firmware that plays the animations itself. The firmware predates
+// the startup command, so startup is shown with the cooldown animation as it always has been. Only the
+// brightness curve and limits of the look can be applied, the firmware picks its own colours.
+type legacyArduino struct {
+	decoder commandDecoder
+	port    io.ReadWriteCloser
+}
+
+// newArduinoOutput creates a lighting output for the arduino connected to the nominated serial port. If the
+// configuration nominates a number of pixels, the animations are rendered here and streamed to the arduino
+// as pixel frames. Otherwise the arduino is sent each command and is left to play the animations itself.
+func newArduinoOutput(port io.ReadWriteCloser, config ArduinoConfiguration, look *look) (io.ReadWriteCloser,
+	error) {
+
+	if config.Pixels == 0 {
+		if config.MaxUpdateRate > 0.0 {
+			port = newOutputScheduler(port, arduinoName(config), config.MaxUpdateRate)
+		}
+
+		a := &legacyArduino{port: port}
+		a.decoder.handle = func(command byte, argument float32) error {
+			switch command {
+			case 'e':
+				look.reportPower(argument)
+				argument = look.brightness(argument)
+			case 'c', 's':
+				// The firmware fades out as the animation progresses, apply the curve to that brightness.
+				look.reportPower(1.0 - argument)
+				argument = 1.0 - look.brightness(1.0-argument)
+				command = 'c'
+			case 'p':
+				// The firmware flashes at full brightness, which can't be limited. Show full energy at the
+				// limited brightness instead when the flash would break the power budget.
+				look.reportPower(1.0)
+				if look.power.scale() < 1.0 {
+					fmt.Printf("WARNING: Power budget can't cover the powerup flash, showing full energy instead\n")
+					command = 'e'
+					argument = look.brightness(1.0)
+				}
+			}
+
+			return sendArduinoCommand(command, argument, port)
+		}
+
+		return a, nil
+	}
+
+	positions, err := pixelLayout(config.Layout, config.Pixels)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(positions) > math.MaxUint16 {
+		return nil, errors.New("too many pixels for a single frame")
+	}
+
+	// Each byte takes ten bits on the serial line, don't send frames faster than the arduino can receive them.
+	frameRate := config.FrameRate
+	maxFrameRate := float64(config.Baud) / 10.0 / float64(frameHeaderLength+3*len(positions))
+	if frameRate <= 0.0 || frameRate > maxFrameRate {
+		fmt.Printf("WARNING: Limiting arduino to %f frames per second\n", maxFrameRate)
+		frameRate = maxFrameRate
+	}
+
+	draw := func(animation *orbAnimation, now time.Time) error {
+		return sendPixelFrame(renderPixels(animation, positions, now), port)
+	}
+
+	return newAnimatedOutput(arduinoName(config), frameRate, look, draw, port), nil
+}
+
+func (a *legacyArduino) Read(p []byte) (n int, err error) {
+	return a.port.Read(p)
+}
+
+func (a *legacyArduino) Write(p []byte) (n int, err error) {
+	return a.decoder.Write(p)
+}
+
+func (a *legacyArduino) Close() error {
+	return a.port.Close()
+}
+
+// commandDecoder unpacks the byte stream generated by sendArduinoCommand and sendPixelFrame back into individual
+// commands. It allows lighting outputs other than the arduino to be driven by the same state machine.
+type commandDecoder struct {
+	pending     []byte
+	handle      func(command byte, argument float32) error
+	handleFrame func(frame []byte) error
+}
+
+func (d *commandDecoder) Write(p []byte) (n int, err error) {
+	d.pending = append(d.pending, p...)
+
+	for len(d.pending) >= commandLength {
+		var e error
+
+		if d.pending[0] == 'f' {
+			// Pixel frames carry the number of pixels, followed by the red, green and blue value of each pixel.
+			length := frameHeaderLength + 3*int(binary.LittleEndian.Uint16(d.pending[1:frameHeaderLength]))
+			if len(d.pending) < length {
+				break
+			}
+
+			if d.handleFrame != nil {
+				e = d.handleFrame(d.pending[frameHeaderLength:length])
+			}
+			d.pending = d.pending[length:]
+		} else {
+			argument := math.Float32frombits(binary.LittleEndian.Uint32(d.pending[1:commandLength]))
+			e = d.handle(d.pending[0], argument)
+			d.pending = d.pending[commandLength:]
+		}
+
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return len(p), err
+}
+
+// animatedOutput is a lighting output that plays the arduino animations itself. The draw function is called
+// at the nominated frame rate to render the animation as it stands at that moment, and failed draws are
+// reported as warnings for the named output.
+type animatedOutput struct {
+	decoder   commandDecoder
+	animation orbAnimation
+	closer    io.Closer
+	done      chan bool
+	finished  chan bool
+}
+
+func newAnimatedOutput(name string, frameRate float64, look *look,
+	draw func(animation *orbAnimation, now time.Time) error, closer io.Closer) *animatedOutput {
+
+	output := &animatedOutput{closer: closer, done: make(chan bool), finished: make(chan bool)}
+	output.animation.look = look
+	output.decoder.handle = func(command byte, argument float32) error {
+		output.animation.update(command, argument, time.Now())
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / frameRate))
+		defer ticker.Stop()
+		defer close(output.finished)
+
+		warnings := sendWarnings{output: name}
+
+		for {
+			select {
+			case <-output.done:
+				return
+			case now := <-ticker.C:
+				warnings.check(draw(&output.animation, now), now)
+
+				_, level := output.animation.current(now)
+				look.reportPower(level)
+			}
+		}
+	}()
+
+	return output
+}
+
+func (o *animatedOutput) Read(p []byte) (n int, err error) {
+	return 0, io.EOF
+}
+
+func (o *animatedOutput) Write(p []byte) (n int, err error) {
+	return o.decoder.Write(p)
+}
+
+func (o *animatedOutput) Close() error {
+	close(o.done)
+	<-o.finished
+
+	return o.closer.Close()
+}
+
+// validDMXChannel returns true if an RGB fixture starting at the nominated DMX address fits within a universe.
+func validDMXChannel(channel int) bool {
+	return channel >= 1 && channel+len(orbColour)-1 <= dmxChannels
+}
+
+// dmxUniverse returns the channel values for a whole DMX universe, with the RGB fixture at the nominated DMX
+// address set to the supplied colour channels.
+func dmxUniverse(channel int, colour []byte) []byte {
+	data := make([]byte, dmxChannels)
+	copy(data[channel-1:], colour)
+
+	return data
+}
@@ -0,0 +1,328 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const configurationReloadInterval = 2 * time.Second
+
+// restartSettings are the settings of the configuration that are only read as the neurone starts, such as the
+// lighting outputs. Changing them in a running neurone has no effect until it restarts.
+var restartSettings = []string{"MasterNeurone", "AllowedPeers", "PowerBudget", "Arduino", "Arduinos", "ArtNet",
+	"SACN", "OPC", "Browsers", "Lighting", "Ambient"}
+
+// liveConfiguration holds the configuration of the running neurone, which can be changed without a restart. The
+// axon and the camera dendrite pick up changes as they run. Settings for the hardware, such as the lighting
+// outputs, only take effect the next time the neurone starts.
+type liveConfiguration struct {
+	mutex    sync.Mutex
+	config   Configuration
+	file     string
+	watchers []func(config Configuration)
+}
+
+// newLiveConfiguration creates a live configuration, starting with the configuration read from the nominated
+// file.
+func newLiveConfiguration(config Configuration, file string) *liveConfiguration {
+	return &liveConfiguration{config: config, file: file}
+}
+
+// get returns the current configuration.
+func (l *liveConfiguration) get() Configuration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.config
+}
+
+// watch calls the nominated function each time the configuration changes.
+func (l *liveConfiguration) watch(watcher func(config Configuration)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.watchers = append(l.watchers, watcher)
+}
+
+// update replaces the current configuration. Returns an error, leaving the current configuration in place, if
+// the new configuration is invalid.
+func (l *liveConfiguration) update(config Configuration) error {
+	if err := validateConfiguration(config); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	l.config = config
+	watchers := append([]func(Configuration){}, l.watchers...)
+	l.mutex.Unlock()
+
+	for _, watcher := range watchers {
+		watcher(config)
+	}
+
+	return nil
+}
+
+// save writes the settings changed since the configuration file was read back to the file. Settings left out
+// of the file stay out of it, so the defaults filled in when it was read (such as the Name) aren't written. The
+// configuration is written to a temporary file first, then renamed over the original, so a neurone that loses
+// power never finds half a file.
+func (l *liveConfiguration) save() error {
+	config := l.get()
+
+	saved, err := parseConfiguration(l.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	settings := map[string]json.RawMessage{}
+	if contents, err := ioutil.ReadFile(l.file); err == nil {
+		if err := json.Unmarshal(contents, &settings); err != nil {
+			return err
+		}
+	}
+
+	current := reflect.ValueOf(config)
+	for i := 0; i < current.NumField(); i++ {
+		name := current.Type().Field(i).Name
+		value, _ := json.Marshal(current.Field(i).Interface())
+		previous, _ := json.Marshal(reflect.ValueOf(saved).Field(i).Interface())
+		if string(value) == string(previous) {
+			continue
+		}
+
+		// Settings are matched without regard to case when the file is read, so replace any spelling of it.
+		for key := range settings {
+			if strings.EqualFold(key, name) {
+				delete(settings, key)
+			}
+		}
+		settings[name] = value
+	}
+
+	contents, err := json.MarshalIndent(settings, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(l.file), "."+filepath.Base(l.file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(append(contents, '\n'))
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), l.file)
+}
+
+// changedRestartSettings returns the names of the restart settings that differ between the configurations.
+func changedRestartSettings(config Configuration, current Configuration) []string {
+	changed := []string{}
+	for _, name := range restartSettings {
+		a, _ := json.Marshal(reflect.ValueOf(config).FieldByName(name).Interface())
+		b, _ := json.Marshal(reflect.ValueOf(current).FieldByName(name).Interface())
+		if string(a) != string(b) {
+			changed = append(changed, name)
+		}
+	}
+
+	return changed
+}
+
+// keepRestartSettings returns the configuration with the restart settings of the current configuration.
+func keepRestartSettings(config Configuration, current Configuration) Configuration {
+	for _, name := range restartSettings {
+		reflect.ValueOf(&config).Elem().FieldByName(name).Set(reflect.ValueOf(current).FieldByName(name))
+	}
+
+	return config
+}
+
+// redactConfiguration hides the secrets in the configuration before it is shown to anyone.
+func redactConfiguration(config Configuration) Configuration {
+	if config.SharedSecret != "" {
+		config.SharedSecret = redacted
+	}
+
+	if config.OperatorToken != "" {
+		config.OperatorToken = redacted
+	}
+
+	return config
+}
+
+// requireOperator returns a handler that only passes on requests carrying the operator token of the neurone,
+// as "Authorization: Bearer <token>". Every request is refused when the neurone has no operator token.
+func requireOperator(live *liveConfiguration, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := live.get().OperatorToken
+		if token == "" {
+			writeError(w, http.StatusForbidden, "operator endpoints are disabled")
+			return
+		}
+
+		if !operatorAuthorised(live, r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "operator token required")
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// operatorAuthorised returns true if the request carries the operator token of the neurone.
+func operatorAuthorised(live *liveConfiguration, r *http.Request) bool {
+	token := live.get().OperatorToken
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+// serveConfiguration handles requests from operators for the configuration of the neurone. A PATCH request
+// carries the settings to change, as JSON, which are applied straight away. The changed settings are written
+// back to the configuration file when the save parameter is true ("?save=true"). Secrets can't be changed, and
+// changes to the restart settings are refused as they wouldn't take effect until the neurone restarts.
+func (l *liveConfiguration) serveConfiguration(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PATCH":
+		if !limitBody(w, r) {
+			return
+		}
+
+		current := l.get()
+
+		// Patch a deep copy, decoding into the slices of the current configuration would change it in place.
+		var config Configuration
+		encoded, _ := json.Marshal(current)
+		json.Unmarshal(encoded, &config)
+
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid configuration: "+err.Error())
+			return
+		}
+
+		config.SharedSecret = current.SharedSecret
+		config.OperatorToken = current.OperatorToken
+		config.TLS = current.TLS
+
+		if changed := changedRestartSettings(config, current); len(changed) > 0 {
+			writeError(w, http.StatusUnprocessableEntity,
+				"restart the neurone to change "+strings.Join(changed, ", "))
+			return
+		}
+
+		if err := l.update(config); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "invalid configuration: "+err.Error())
+			return
+		}
+
+		if r.FormValue("save") == "true" {
+			if err := l.save(); err != nil {
+				writeError(w, http.StatusInternalServerError,
+					"configuration applied but not saved: "+err.Error())
+				return
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactConfiguration(l.get()))
+}
+
+// reload reads the configuration file again, applying the new configuration if it is valid. Returns an error,
+// leaving the current configuration in place, if the file can't be parsed or the configuration is invalid.
+func (l *liveConfiguration) reload() error {
+	config, err := parseConfiguration(l.file)
+	if err != nil {
+		return err
+	}
+
+	current := l.get()
+	if config.SharedSecret != current.SharedSecret || config.OperatorToken != current.OperatorToken ||
+		config.TLS != current.TLS {
+
+		fmt.Printf("WARNING: Changes to secrets and TLS take effect when the neurone restarts\n")
+		config.SharedSecret = current.SharedSecret
+		config.OperatorToken = current.OperatorToken
+		config.TLS = current.TLS
+	}
+
+	if changed := changedRestartSettings(config, current); len(changed) > 0 {
+		fmt.Printf("WARNING: Changes to %s take effect when the neurone restarts\n", strings.Join(changed, ", "))
+		config = keepRestartSettings(config, current)
+	}
+
+	return l.update(config)
+}
+
+// watchConfiguration checks the configuration file for changes at the nominated interval, reloading the
+// configuration whenever the file changes. Invalid files are reported and otherwise ignored.
+func watchConfiguration(live *liveConfiguration, interval time.Duration) {
+	var modified time.Time
+	var size int64
+
+	if info, err := os.Stat(live.file); err == nil {
+		modified, size = info.ModTime(), info.Size()
+	}
+
+	for {
+		time.Sleep(interval)
+
+		info, err := os.Stat(live.file)
+		if err != nil || (info.ModTime().Equal(modified) && info.Size() == size) {
+			continue
+		}
+		modified, size = info.ModTime(), info.Size()
+
+		if err := live.reload(); err != nil {
+			fmt.Printf("ERROR: Ignoring invalid configuration %s: %s\n", live.file, err)
+		} else {
+			fmt.Printf("INFO: Reloaded configuration %s\n", live.file)
+		}
+	}
+}
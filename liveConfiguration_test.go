@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configurationRequest sends a request to the configuration endpoint with the nominated operator token.
+func configurationRequest(live *liveConfiguration, method string, target string, token string,
+	body string) *httptest.ResponseRecorder {
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	requireOperator(live, live.serveConfiguration)(w, r)
+	return w
+}
+
+func TestOperatorToken(t *testing.T) {
+	live := newLiveConfiguration(defaultConfiguration(), "")
+	if w := configurationRequest(live, "GET", "/config", "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("configuration served without an operator token configured.")
+	}
+
+	config := defaultConfiguration()
+	config.OperatorToken = "operator"
+	live = newLiveConfiguration(config, "")
+
+	if w := configurationRequest(live, "GET", "/config", "visitor", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("configuration served with the wrong operator token.")
+	}
+
+	w := configurationRequest(live, "GET", "/config", "operator", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"operator"`) {
+		t.Errorf("configuration not served, or operator token revealed.")
+	}
+}
+
+func TestPatchConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "neurone-config")
+	if err != nil {
+		t.Fatalf("unable to create configuration directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := defaultConfiguration()
+	config.OperatorToken = "operator"
+	config.SharedSecret = "orbs"
+	file := filepath.Join(dir, "gasworks.json")
+	ioutil.WriteFile(file, []byte(`{"listenAddress": "127.0.0.1:8080", "Arduino": {"Port": "/dev/ttyACM0"},
+		"OperatorToken": "operator", "SharedSecret": "orbs"}`), 0644)
+	config.ListenAddress = "127.0.0.1:8080"
+	config.Arduino.Port = "/dev/ttyACM0"
+	live := newLiveConfiguration(config, file)
+
+	var watched Configuration
+	live.watch(func(c Configuration) {
+		watched = c
+	})
+
+	w := configurationRequest(live, "PATCH", "/config", "operator",
+		`{"DecayPerSecond": 0.01, "SharedSecret": "********",
+		  "AdjacentNeurones": [{"Transfer": 0.5, "Address": "http://10.1.1.5:8080/"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("valid configuration refused with %d: %s", w.Code, w.Body.String())
+	}
+
+	var response Configuration
+	json.NewDecoder(w.Body).Decode(&response)
+	if response.DecayPerSecond != 0.01 || response.MovementThreshold != 1.0 || len(response.AdjacentNeurones) != 1 {
+		t.Errorf("configuration not patched %+v", response)
+	}
+
+	if live.get().DecayPerSecond != 0.01 || watched.DecayPerSecond != 0.01 {
+		t.Errorf("patched configuration not applied.")
+	}
+
+	if live.get().SharedSecret != "orbs" {
+		t.Errorf("shared secret changed by patch.")
+	}
+
+	invalid := []string{`{"OpticalFlowScale": 0}`, `{"AdjacentNeurones": [{"Address": "orb"}]}`,
+		`{"PowerBudget": 100}`, `{"MasterNeurone": true}`, `{"Lighting": {"Gamma": 2.2}}`}
+	for _, body := range invalid {
+		if w := configurationRequest(live, "PATCH", "/config", "operator", body); w.Code != 422 {
+			t.Errorf("invalid configuration %s returned %d", body, w.Code)
+		}
+	}
+
+	if w := configurationRequest(live, "PATCH", "/config", "operator", `{"Decay": 1}`); w.Code != 400 {
+		t.Errorf("unknown setting returned %d", w.Code)
+	}
+
+	if live.get().OpticalFlowScale != 300.0 || live.get().PowerBudget != 0.0 || live.get().MasterNeurone {
+		t.Errorf("invalid configuration applied.")
+	}
+
+	w = configurationRequest(live, "PATCH", "/config?save=true", "operator", `{"PowerUpThreshold": 0.5}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unable to save configuration: %s", w.Body.String())
+	}
+
+	saved, err := parseConfiguration(file)
+	if err != nil {
+		t.Fatalf("unable to parse saved configuration: %s", err)
+	}
+
+	if saved.PowerUpThreshold != 0.5 || saved.DecayPerSecond != 0.01 || saved.SharedSecret != "orbs" ||
+		saved.ListenAddress != "127.0.0.1:8080" || saved.Arduino.Port != "/dev/ttyACM0" {
+		t.Errorf("incorrect configuration saved %+v", saved)
+	}
+
+	var settings map[string]interface{}
+	contents, _ := ioutil.ReadFile(file)
+	json.Unmarshal(contents, &settings)
+	if _, found := settings["Name"]; found || len(settings) != 7 {
+		t.Errorf("defaults written to the configuration file %v", settings)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temporary configuration file left behind.")
+	}
+}
+
+func TestReloadConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "neurone-config")
+	if err != nil {
+		t.Fatalf("unable to create configuration directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "gasworks.json")
+	ioutil.WriteFile(file, []byte(`{"DecayPerSecond": 0.01}`), 0644)
+
+	config, _ := parseConfiguration(file)
+	live := newLiveConfiguration(config, file)
+	go watchConfiguration(live, 10*time.Millisecond)
+
+	// Give the watcher a moment to note the original file before changing it.
+	time.Sleep(50 * time.Millisecond)
+	ioutil.WriteFile(file, []byte(`{"DecayPerSecond": 0.02, "MovementThreshold": 2.0, "PowerBudget": 100}`), 0644)
+	for i := 0; i < 100 && live.get().DecayPerSecond != 0.02; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if live.get().DecayPerSecond != 0.02 || live.get().MovementThreshold != 2.0 {
+		t.Errorf("changed configuration file not reloaded.")
+	}
+
+	if live.get().PowerBudget != 0.0 {
+		t.Errorf("restart setting changed by reloading the configuration.")
+	}
+
+	for _, contents := range []string{`{"DecayPerSecond": `, `{"OpticalFlowScale": -1.0}`} {
+		ioutil.WriteFile(file, []byte(contents), 0644)
+		if live.reload() == nil {
+			t.Errorf("invalid configuration %s reloaded", contents)
+		}
+	}
+
+	if live.get().DecayPerSecond != 0.02 || live.get().OpticalFlowScale != 300.0 {
+		t.Errorf("previous configuration not kept after an invalid reload.")
+	}
+}
+
+func TestMoveWebDendrite(t *testing.T) {
+	config := defaultConfiguration()
+	config.ListenAddress = "127.0.0.1:0"
+	live := newLiveConfiguration(config, "")
+
+	web, err := startWebDendrite(live, func() (*webDendrite, error) {
+		return newWebDendrite(newInputBus(live, nil), live, newPowerLimiter(0.0), nil, newMonitor(config), newOverrides(nil))
+	})
+	if err != nil {
+		t.Fatalf("unable to start web dendrite: %s", err)
+	}
+	previous := web.Addr().String()
+
+	// Find a free port to move to.
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.Close()
+
+	config.ListenAddress = address
+	live.update(config)
+	for i := 0; i < 100 && web.Addr().String() != address; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if web.Addr().String() != address {
+		t.Fatalf("web dendrite didn't move to %s", address)
+	}
+
+	response, err := http.Get("http://" + address + "/status")
+	if err != nil {
+		t.Fatalf("moved web dendrite not listening: %s", err)
+	}
+	response.Body.Close()
+
+	if _, err := http.Get("http://" + previous + "/status"); err == nil {
+		t.Errorf("web dendrite still listening on %s", previous)
+	}
+
+	// After several quick changes the web dendrite ends up on the last address.
+	addresses := []string{}
+	for i := 0; i < 3; i++ {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		addresses = append(addresses, listener.Addr().String())
+		listener.Close()
+	}
+
+	for _, address = range addresses {
+		config.ListenAddress = address
+		live.update(config)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if web.Addr().String() != address {
+		t.Errorf("web dendrite on %s instead of the last address %s", web.Addr(), address)
+	}
+
+	web.current.Stop()
+}
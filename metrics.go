@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	energyBuckets  = []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1.0, 2.0}
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.0}
+	flowBuckets    = []float64{0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5}
+	allStates      = []string{"wait", "startup", "accumulate", "powerup", "cooldown", "held"}
+)
+
+// histogram counts observations into cumulative buckets, as exposed to Prometheus.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// observe adds a value to the histogram.
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += value
+	h.count++
+}
+
+// writeMetricHeader writes the help and type lines that precede the samples of a metric.
+func writeMetricHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeSample writes a single sample of a metric, with an optional label.
+func writeSample(w io.Writer, name string, label string, labelValue string, value float64) {
+	if label != "" {
+		name = fmt.Sprintf("%s{%s=\"%s\"}", name, label, escapeLabel(labelValue))
+	}
+
+	fmt.Fprintf(w, "%s %s\n", name, formatSample(value))
+}
+
+// writeHistogram writes the buckets, sum and count of a histogram, with an optional label.
+func writeHistogram(w io.Writer, name string, label string, labelValue string, h *histogram) {
+	labels := ""
+	if label != "" {
+		labels = fmt.Sprintf("%s=\"%s\",", label, escapeLabel(labelValue))
+	}
+
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatSample(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatSample(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// formatSample formats a sample value as expected by Prometheus.
+func formatSample(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return fmt.Sprintf("%g", value)
+}
+
+// escapeLabel escapes a label value for the Prometheus text format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// sortedKeys returns the keys of a map of histograms in order, so metrics are always written in the same order.
+func sortedKeys(m map[string]*histogram) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// delivered records the outcome of firing the impulse into an adjacent neurone.
+func (m *monitor) delivered(address string, i impulse, latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e := event{Type: firedEvent, Time: time.Now(), State: m.state, Energy: m.energy, Impulse: &i, Address: address}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	m.publish(e)
+
+	if err != nil {
+		m.axonFailures[address]++
+		return
+	}
+
+	if m.axonLatency[address] == nil {
+		m.axonLatency[address] = newHistogram(latencyBuckets)
+	}
+	m.axonLatency[address].observe(latency.Seconds())
+}
+
+// cameraFrame records the time taken to calculate the optical flow of a camera frame, and updates the frame
+// rate of the camera.
+func (m *monitor) cameraFrame(processing time.Duration, now time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.lastFrame.IsZero() {
+		if dt := now.Sub(m.lastFrame).Seconds(); dt > 0.0 {
+			m.frameRate = 0.9*m.frameRate + 0.1/dt
+		}
+	}
+	m.lastFrame = now
+	m.flowTime.observe(processing.Seconds())
+}
+
+// serialWriteFailed counts the writes to an arduino that failed.
+func (m *monitor) serialWriteFailed() {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.serialErrors++
+}
+
+// inputDropped counts the inputs from the nominated source that were dropped before reaching the axon.
+func (m *monitor) inputDropped(source string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.droppedInputs[source]++
+}
+
+// writeMetrics writes every metric of the neurone in the Prometheus text format. The monitor is locked while
+// writing, so w should be a buffer rather than a connection to a client.
+func (m *monitor) writeMetrics(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	writeMetricHeader(w, "neurone_energy", "gauge", "Current energy of the neurone.")
+	writeSample(w, "neurone_energy", "", "", float64(m.energy))
+
+	writeMetricHeader(w, "neurone_state", "gauge", "Current state of the neurone, 1 for the active state.")
+	for _, state := range allStates {
+		active := 0.0
+		if state == m.state {
+			active = 1.0
+		}
+		writeSample(w, "neurone_state", "state", state, active)
+	}
+
+	writeMetricHeader(w, "neurone_firings_total", "counter", "Times the neurone fired into its axon.")
+	writeSample(w, "neurone_firings_total", "", "", float64(m.firings))
+	writeMetricHeader(w, "neurone_powerups_total", "counter", "Times the neurone entered the powerup state.")
+	writeSample(w, "neurone_powerups_total", "", "", float64(m.powerups))
+	writeMetricHeader(w, "neurone_cooldowns_total", "counter", "Times the neurone entered the cooldown state.")
+	writeSample(w, "neurone_cooldowns_total", "", "", float64(m.cooldowns))
+
+	writeMetricHeader(w, "neurone_received_energy", "histogram", "Energy received by the neurone, by source.")
+	for _, source := range sortedKeys(m.receivedEnergy) {
+		writeHistogram(w, "neurone_received_energy", "source", source, m.receivedEnergy[source])
+	}
+
+	writeMetricHeader(w, "neurone_dropped_inputs_total", "counter",
+		"Inputs dropped before reaching the axon, by source.")
+	sources := []string{}
+	for source := range m.droppedInputs {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		writeSample(w, "neurone_dropped_inputs_total", "source", source, float64(m.droppedInputs[source]))
+	}
+
+	writeMetricHeader(w, "neurone_axon_latency_seconds", "histogram",
+		"Time taken to deliver a firing to each adjacent neurone.")
+	for _, address := range sortedKeys(m.axonLatency) {
+		writeHistogram(w, "neurone_axon_latency_seconds", "neurone", address, m.axonLatency[address])
+	}
+
+	writeMetricHeader(w, "neurone_axon_failures_total", "counter",
+		"Firings that could not be delivered to each adjacent neurone.")
+	addresses := []string{}
+	for address := range m.axonFailures {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		writeSample(w, "neurone_axon_failures_total", "neurone", address, float64(m.axonFailures[address]))
+	}
+
+	writeMetricHeader(w, "neurone_camera_frame_rate", "gauge", "Frames per second processed by the camera.")
+	writeSample(w, "neurone_camera_frame_rate", "", "", m.frameRate)
+	writeMetricHeader(w, "neurone_camera_flow_seconds", "histogram",
+		"Time taken to calculate the optical flow of each camera frame.")
+	writeHistogram(w, "neurone_camera_flow_seconds", "", "", m.flowTime)
+
+	writeMetricHeader(w, "neurone_serial_write_errors_total", "counter", "Writes to an arduino that failed.")
+	writeSample(w, "neurone_serial_write_errors_total", "", "", float64(m.serialErrors))
+}
+
+// serveMetrics handles scrapes of the neurone by Prometheus. The metrics are rendered before the response is
+// written, so a slow scrape doesn't hold up the axon.
+func (m *monitor) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var metrics bytes.Buffer
+	m.writeMetrics(&metrics)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.WriteTo(w)
+}
+
+// monitoredPort counts the failed writes to the serial port of an arduino.
+type monitoredPort struct {
+	io.ReadWriteCloser
+	monitor *monitor
+}
+
+func (p monitoredPort) Write(b []byte) (n int, err error) {
+	n, err = p.ReadWriteCloser.Write(b)
+	if err != nil {
+		p.monitor.serialWriteFailed()
+	}
+
+	return n, err
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redacted = "********"
+
+	// otherSource counts the impulses received from anyone outside the cluster.
+	otherSource = "other"
+)
+
+// neuroneStatus is the response to GET /status, describing the neurone as it runs.
+type neuroneStatus struct {
+	Name          string
+	State         string
+	Energy        float32
+	TimeInState   float64
+	LastFired     *time.Time
+	Inputs        map[string]int
+	Camera        string
+	Outputs       map[string]string
+	Override      *override `json:",omitempty"`
+	Configuration Configuration
+}
+
+// monitor keeps track of the state of the neurone, its dendrites and lighting outputs, so they can be reported
+// while the neurone runs.
+type monitor struct {
+	mutex        sync.Mutex
+	config       Configuration
+	state        string
+	energy       float32
+	stateStarted time.Time
+	lastFired    time.Time
+	inputs       map[string]int
+	camera       string
+	outputs      map[string]string
+	override     *override
+
+	firings        uint64
+	powerups       uint64
+	cooldowns      uint64
+	receivedEnergy map[string]*histogram
+	axonLatency    map[string]*histogram
+	axonFailures   map[string]uint64
+	frameRate      float64
+	lastFrame      time.Time
+	flowTime       *histogram
+	serialErrors   uint64
+	droppedInputs  map[string]uint64
+
+	subscribers map[*subscriber]bool
+}
+
+// newMonitor creates a monitor for a neurone running with the nominated configuration.
+func newMonitor(config Configuration) *monitor {
+	return &monitor{config: config, state: "wait", stateStarted: time.Now(), inputs: map[string]int{},
+		camera: "starting", outputs: map[string]string{}, receivedEnergy: map[string]*histogram{},
+		axonLatency: map[string]*histogram{}, axonFailures: map[string]uint64{}, droppedInputs: map[string]uint64{},
+		flowTime: newHistogram(flowBuckets), subscribers: map[*subscriber]bool{}}
+}
+
+// stateName returns the name of the nominated state function, such as "accumulate".
+func stateName(state stateFn) string {
+	name := runtime.FuncForPC(reflect.ValueOf(state).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// update records the state and energy of the neurone after each step of the axon, publishing the change to
+// subscribers.
+func (m *monitor) update(state string, energy float32, now time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if state != m.state {
+		switch state {
+		case "cooldown":
+			m.cooldowns++
+		case "powerup":
+			m.powerups++
+		}
+
+		m.state = state
+		m.stateStarted = now
+		m.publish(event{Type: stateEvent, Time: now, State: state, Energy: energy})
+	}
+	m.energy = energy
+	m.publish(event{Type: energyEvent, Time: now, State: state, Energy: energy})
+}
+
+// fired records the neurone firing into its axon.
+func (m *monitor) fired(now time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastFired = now
+	m.firings++
+}
+
+// received counts the impulses delivered to the neurone by each source, along with the energy they carry. The
+// source is named by the dendrite rather than the sender of the impulse, so clients can't create new counters.
+func (m *monitor) received(source string, i impulse) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.inputs[source]++
+
+	if m.receivedEnergy[source] == nil {
+		m.receivedEnergy[source] = newHistogram(energyBuckets)
+	}
+	m.receivedEnergy[source].observe(float64(i.Energy))
+}
+
+// setConfiguration records the configuration the neurone is now running with.
+func (m *monitor) setConfiguration(config Configuration) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.config = config
+}
+
+// setCamera records the status of the camera dendrite.
+func (m *monitor) setCamera(status string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.camera = status
+}
+
+// setOutput records the status of the nominated lighting output, such as the serial connection to an arduino.
+func (m *monitor) setOutput(output string, status string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.outputs[output] = status
+}
+
+// setOverride records the override an operator has applied to the neurone, nil if there is none.
+func (m *monitor) setOverride(o *override) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.override = o
+}
+
+// status returns a snapshot of the neurone. Secrets are left out of the configuration.
+func (m *monitor) status(now time.Time) neuroneStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s := neuroneStatus{Name: m.config.Name, State: m.state, Energy: m.energy,
+		TimeInState: now.Sub(m.stateStarted).Seconds(), Inputs: map[string]int{}, Camera: m.camera,
+		Outputs: map[string]string{}, Override: m.override, Configuration: m.config}
+
+	if !m.lastFired.IsZero() {
+		lastFired := m.lastFired
+		s.LastFired = &lastFired
+	}
+
+	for source, count := range m.inputs {
+		s.Inputs[source] = count
+	}
+
+	for output, status := range m.outputs {
+		s.Outputs[output] = status
+	}
+
+	s.Configuration = redactConfiguration(s.Configuration)
+	return s
+}
+
+// serveStatus handles requests for the status of the neurone.
+func (m *monitor) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.status(time.Now()))
+}
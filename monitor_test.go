@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateName(t *testing.T) {
+	if stateName(accumulate) != "accumulate" || stateName(cooldown) != "cooldown" {
+		t.Errorf("incorrect state names %s and %s", stateName(accumulate), stateName(cooldown))
+	}
+}
+
+func TestStatus(t *testing.T) {
+	config := defaultConfiguration()
+	config.SharedSecret = "orbs"
+	m := newMonitor(config)
+
+	start := time.Now()
+	m.update("accumulate", 0.5, start)
+	m.update("accumulate", 0.9, start.Add(time.Second))
+	m.update("cooldown", 0.0, start.Add(2*time.Second))
+	m.fired(start.Add(2 * time.Second))
+	m.received("camera", newImpulse("camera", 0.1, ""))
+	m.received("camera", newImpulse("camera", 0.1, ""))
+	m.received("left", newImpulse("left", 0.8, ""))
+	m.setCamera("running")
+	m.setOutput("arduino orb", "connected")
+
+	w := httptest.NewRecorder()
+	m.serveStatus(w, httptest.NewRequest("GET", "/status", nil))
+
+	var s neuroneStatus
+	if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+		t.Fatalf("unable to decode status: %s", err)
+	}
+
+	if s.State != "cooldown" || s.Energy != 0.0 {
+		t.Errorf("incorrect state %s with energy %f", s.State, s.Energy)
+	}
+
+	if s.LastFired == nil || !s.LastFired.Equal(start.Add(2*time.Second)) {
+		t.Errorf("incorrect last firing time %v", s.LastFired)
+	}
+
+	if s.Inputs["camera"] != 2 || s.Inputs["left"] != 1 {
+		t.Errorf("incorrect input counts %v", s.Inputs)
+	}
+
+	if s.Camera != "running" || s.Outputs["arduino orb"] != "connected" {
+		t.Errorf("incorrect camera %s or output status %v", s.Camera, s.Outputs)
+	}
+
+	if s.Configuration.SharedSecret == "orbs" {
+		t.Errorf("shared secret revealed in the status.")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := newMonitor(defaultConfiguration())
+	m.update("accumulate", 0.5, time.Now())
+	m.update("powerup", 0.75, time.Now())
+	m.received("camera", newImpulse("camera", 0.02, ""))
+	m.received("left\"", newImpulse("left", 0.8, ""))
+	m.delivered("http://10.1.1.5:8080/", newImpulse("test", 0.8, ""), 20*time.Millisecond, nil)
+	m.delivered("http://10.1.1.4:8080/", newImpulse("test", 0.8, ""), time.Second, errors.New("unreachable"))
+	m.serialWriteFailed()
+
+	w := httptest.NewRecorder()
+	m.serveMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := w.Body.String()
+
+	expected := []string{
+		"# TYPE neurone_energy gauge\nneurone_energy 0.75\n",
+		"neurone_state{state=\"powerup\"} 1\n",
+		"neurone_state{state=\"accumulate\"} 0\n",
+		"neurone_powerups_total 1\n",
+		"neurone_firings_total 0\n",
+		"neurone_received_energy_bucket{source=\"camera\",le=\"0.05\"} 1\n",
+		"neurone_received_energy_bucket{source=\"camera\",le=\"0.01\"} 0\n",
+		"neurone_received_energy_count{source=\"left\\\"\"} 1\n",
+		"neurone_axon_latency_seconds_bucket{neurone=\"http://10.1.1.5:8080/\",le=\"+Inf\"} 1\n",
+		"neurone_axon_failures_total{neurone=\"http://10.1.1.4:8080/\"} 1\n",
+		"neurone_camera_flow_seconds_count 0\n",
+		"neurone_serial_write_errors_total 1\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(metrics, e) {
+			t.Errorf("metrics missing %q", e)
+		}
+	}
+}
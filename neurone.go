@@ -31,22 +31,88 @@ import (
 func main() {
 	fmt.Printf("Gasworks neurone\n")
 
+	// Run one of the tools for working with the arduino or the installation instead of a neurone.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "virtual-arduino":
+			virtualArduino(os.Args[2:])
+			return
+		case "replay":
+			replaySerial(os.Args[2:])
+			return
+		case "certificates":
+			certificates(os.Args[2:])
+			return
+		}
+	}
+
 	configFile := "/home/pi/gasworks/neurone/bin/gasworks.json"
 	if len(os.Args) > 1 {
 		configFile = os.Args[1]
 	}
 
-	configuration, _ := parseConfiguration(configFile)
-	deltaE := make(chan float32)
+	configuration, err := parseConfiguration(configFile)
+	if os.IsNotExist(err) {
+		fmt.Printf("WARNING: No configuration file %s, using the default configuration\n", configFile)
+	} else if err != nil {
+		fmt.Printf("ERROR: Unable to read configuration %s: %s\n", configFile, err)
+		os.Exit(1)
+	}
+
+	if err := validateConfiguration(configuration); err != nil {
+		fmt.Printf("ERROR: Invalid configuration %s: %s\n", configFile, err)
+		os.Exit(1)
+	}
+
+	power := newPowerLimiter(configuration.PowerBudget)
+	ambient, err := newAmbientLight(configuration.Ambient)
+	if err != nil {
+		fmt.Printf("WARNING: Invalid ambient light configuration, ambient compensation disabled: %s\n", err)
+	}
+
+	live := newLiveConfiguration(configuration, configFile)
+	monitor := newMonitor(configuration)
+	live.watch(monitor.setConfiguration)
+	overrides := newOverrides(monitor)
+	inputs := newInputBus(live, monitor)
+	peers, err := newPeerClient(configuration, monitor)
+	if err != nil {
+		fmt.Printf("ERROR: Unable to load TLS certificates: %s\n", err)
+		os.Exit(1)
+	}
+
+	if configuration.SharedSecret == "" {
+		fmt.Printf("WARNING: No shared secret, requests between neurones are not signed\n")
+	}
 
 	fmt.Println("Starting Axon")
-	go axon(deltaE, configuration)
+	go axon(inputs, live, peers, power, ambient, monitor, overrides)
 
 	fmt.Println("Starting Web Dendrite")
-	go dendriteWeb(deltaE, configuration)
+	web, err := startWebDendrite(live, func() (*webDendrite, error) {
+		return newWebDendrite(inputs, live, power, peers, monitor, overrides)
+	})
+	if err != nil {
+		fmt.Printf("ERROR: Unable to start web dendrite on %s: %s\n", configuration.ListenAddress, err)
+		os.Exit(1)
+	}
+
+	go func() {
+		for err := range web.Errors() {
+			fmt.Printf("ERROR: Web dendrite stopped: %s\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	go watchConfiguration(live, configurationReloadInterval)
+
+	if configuration.MasterNeurone {
+		fmt.Println("Starting Power Balancer")
+		go balancePower(live, power, peers)
+	}
 
 	fmt.Println("Starting Camera Dendrite")
-	dendriteCam(deltaE, configuration)
+	dendriteCam(inputs, live, ambient, monitor)
 
 	// Make sure we block if no webcam is found and DendriteCam returns straight away.
 	select {}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	opcPort           = "7890"
+	opcSetPixels      = 0
+	opcFrameRate      = 60.0
+	opcReconnectDelay = 1 * time.Second
+)
+
+// opc streams pixel frames to an Open Pixel Control server, such as the one that drives a Fadecandy board.
+type opc struct {
+	address   string
+	channel   uint8
+	positions []pixelPosition
+	conn      net.Conn
+	lastDial  time.Time
+}
+
+// newOPCOutput creates a lighting output that renders the orb animations pixel by pixel and streams them to
+// the Open Pixel Control server nominated in the configuration. The connection is re-established if the
+// server goes away. Returns an error if the pixel layout is invalid.
+func newOPCOutput(config OPCConfiguration, look *look) (io.ReadWriteCloser, error) {
+	positions, err := pixelLayout(config.Layout, config.Pixels)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(positions)*len(orbColour) > math.MaxUint16 {
+		return nil, errors.New("too many pixels for a single OPC message")
+	}
+
+	address := config.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, opcPort)
+	}
+
+	o := &opc{address: address, channel: config.Channel, positions: positions}
+	return newAnimatedOutput("opc "+config.Address, opcFrameRate, look, o.draw, o), nil
+}
+
+// draw sends a single frame of the orb as it appears at the nominated time.
+func (o *opc) draw(animation *orbAnimation, now time.Time) error {
+	if o.conn == nil {
+		// Don't hammer the server with connection attempts when it isn't running.
+		if now.Sub(o.lastDial) < opcReconnectDelay {
+			return nil
+		}
+		o.lastDial = now
+
+		conn, err := net.DialTimeout("tcp", o.address, opcReconnectDelay)
+		if err != nil {
+			return err
+		}
+		o.conn = conn
+	}
+
+	_, err := o.conn.Write(opcMessage(o.channel, renderPixels(animation, o.positions, now)))
+	if err != nil {
+		o.Close()
+	}
+
+	return err
+}
+
+func (o *opc) Close() error {
+	if o.conn == nil {
+		return nil
+	}
+
+	err := o.conn.Close()
+	o.conn = nil
+
+	return err
+}
+
+// opcMessage packages a frame of pixels into a 'set pixel colours' message for the nominated OPC channel.
+func opcMessage(channel uint8, frame []byte) []byte {
+	message := new(bytes.Buffer)
+
+	message.WriteByte(channel)
+	message.WriteByte(opcSetPixels)
+	binary.Write(message, binary.BigEndian, uint16(len(frame)))
+	message.Write(frame)
+
+	return message.Bytes()
+}
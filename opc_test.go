@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPixelLayout(t *testing.T) {
+	strip, err := pixelLayout("strip", 3)
+	if err != nil || strip[0].x != -1.0 || strip[1].x != 0.0 || strip[2].x != 1.0 {
+		t.Errorf("incorrect positions for a strip of pixels.")
+	}
+
+	ring, err := pixelLayout("ring", 4)
+	if err != nil || math.Abs(math.Hypot(ring[1].x, ring[1].y)-1.0) > 0.0001 {
+		t.Errorf("incorrect positions for a ring of pixels.")
+	}
+
+	_, err = pixelLayout("spiral", 4)
+	if err == nil {
+		t.Errorf("error not raised for an unknown pixel layout.")
+	}
+}
+
+func TestOPCOutput(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to open local TCP listener.")
+	}
+	defer listener.Close()
+
+	look, _ := newLook(defaultConfiguration().Lighting)
+	output, err := newOPCOutput(OPCConfiguration{listener.Addr().String(), 2, 8, "strip", DeviceConfiguration{"test", 1.0, 0.0}}, look)
+	if err != nil {
+		t.Fatalf("unable to create OPC output: %s", err)
+	}
+	defer output.Close()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("OPC output did not connect.")
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	message := make([]byte, 4+8*3)
+	if _, err := io.ReadFull(conn, message); err != nil {
+		t.Fatalf("did not receive an OPC message.")
+	}
+
+	if message[0] != 2 || message[1] != opcSetPixels {
+		t.Errorf("incorrect OPC channel or command.")
+	}
+
+	if message[2] != 0 || message[3] != 8*3 {
+		t.Errorf("incorrect OPC message length.")
+	}
+}
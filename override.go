@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	fireOverride     = "fire"
+	powerupOverride  = "powerup"
+	cooldownOverride = "cooldown"
+	darkOverride     = "dark"
+	pinOverride      = "pin"
+)
+
+// overrideRequest is the body of a POST to /override. Energy is only used when pinning the neurone, and
+// Duration is the number of seconds to hold the neurone dark or pinned, zero holds it until cleared.
+type overrideRequest struct {
+	Command  string
+	Energy   float32
+	Duration float64
+}
+
+// override is a command given to the neurone by an operator. Fire, powerup and cooldown happen once, while
+// dark and pin hold the neurone at a fixed energy until they expire or are cleared.
+type override struct {
+	Command string
+	Energy  float32
+	Expires *time.Time `json:",omitempty"`
+}
+
+// held returns true if the override holds the neurone, rather than happening once.
+func (o override) held() bool {
+	return o.Command == darkOverride || o.Command == pinOverride
+}
+
+// overrides passes the commands of operators into the axon, and reports them to the monitor.
+type overrides struct {
+	mutex   sync.Mutex
+	pending *override
+	active  *override
+	monitor *monitor
+}
+
+// newOverrides creates an empty set of overrides, reporting each change to the monitor.
+func newOverrides(monitor *monitor) *overrides {
+	return &overrides{monitor: monitor}
+}
+
+// set applies the command of an operator. A command that happens once replaces any override holding the
+// neurone. Returns an error if the request is invalid.
+func (o *overrides) set(request overrideRequest, now time.Time) error {
+	if request.Duration < 0.0 || !finite(request.Duration) {
+		return errors.New("duration must be zero or more seconds")
+	}
+
+	next := override{Command: request.Command}
+	switch request.Command {
+	case fireOverride, powerupOverride, cooldownOverride, darkOverride:
+	case pinOverride:
+		if !finite(float64(request.Energy)) || request.Energy < 0.0 || request.Energy > 1.0 {
+			return errors.New("pinned energy must be between 0.0 and 1.0")
+		}
+		next.Energy = request.Energy
+	default:
+		return fmt.Errorf("unknown override command %q", request.Command)
+	}
+
+	if next.held() && request.Duration > 0.0 {
+		expires := now.Add(time.Duration(request.Duration * float64(time.Second)))
+		next.Expires = &expires
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	fmt.Printf("INFO: Operator override %s\n", next.Command)
+	if next.held() {
+		o.pending = nil
+		o.active = &next
+	} else {
+		o.pending = &next
+		o.active = nil
+	}
+	o.report()
+
+	return nil
+}
+
+// clear releases the neurone from any override.
+func (o *overrides) clear() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	fmt.Printf("INFO: Operator override cleared\n")
+	o.pending = nil
+	o.active = nil
+	o.report()
+}
+
+// next returns the command waiting to happen once, and the override holding the neurone. Either is empty if
+// there is no such override. Overrides that have expired are released.
+func (o *overrides) next(now time.Time) (command string, held *override) {
+	if o == nil {
+		return "", nil
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.pending != nil {
+		command = o.pending.Command
+		o.pending = nil
+		o.report()
+	}
+
+	if o.active != nil && o.active.Expires != nil && !now.Before(*o.active.Expires) {
+		fmt.Printf("INFO: Operator override %s expired\n", o.active.Command)
+		o.active = nil
+		o.report()
+	}
+
+	if o.active != nil {
+		active := *o.active
+		held = &active
+	}
+
+	return command, held
+}
+
+// report passes the current override on to the monitor. Must be called with the mutex held.
+func (o *overrides) report() {
+	current := o.active
+	if o.pending != nil {
+		current = o.pending
+	}
+
+	if current == nil {
+		o.monitor.setOverride(nil)
+		return
+	}
+
+	reported := *current
+	o.monitor.setOverride(&reported)
+}
+
+// serveOverride handles operators overriding the neurone. POST applies an override, DELETE clears it.
+func (o *overrides) serveOverride(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		if !limitBody(w, r) {
+			return
+		}
+
+		var request overrideRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid override: "+err.Error())
+			return
+		}
+
+		if err := o.set(request, time.Now()); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+	case "DELETE":
+		o.clear()
+
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
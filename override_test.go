@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverrides(t *testing.T) {
+	m := newMonitor(defaultConfiguration())
+	o := newOverrides(m)
+	now := time.Now()
+
+	if err := o.set(overrideRequest{pinOverride, 0.6, 10.0}, now); err != nil {
+		t.Fatalf("unable to pin the neurone: %s", err)
+	}
+
+	command, pinned := o.next(now)
+	if command != "" || pinned == nil || pinned.Energy != 0.6 {
+		t.Errorf("neurone not pinned at the nominated energy.")
+	}
+
+	if s := m.status(now); s.Override == nil || s.Override.Command != pinOverride || s.Override.Expires == nil {
+		t.Errorf("pinned override not reported in the status.")
+	}
+
+	if _, pinned = o.next(now.Add(11 * time.Second)); pinned != nil {
+		t.Errorf("pinned override did not expire.")
+	}
+
+	if m.status(now).Override != nil {
+		t.Errorf("expired override still reported in the status.")
+	}
+
+	o.set(overrideRequest{darkOverride, 0.0, 0.0}, now)
+	o.set(overrideRequest{fireOverride, 0.0, 0.0}, now)
+	if command, pinned = o.next(now); command != fireOverride || pinned != nil {
+		t.Errorf("firing the neurone did not release it from being held dark.")
+	}
+
+	if command, _ = o.next(now); command != "" {
+		t.Errorf("neurone forced to fire more than once.")
+	}
+
+	for _, request := range []overrideRequest{{"explode", 0.0, 0.0}, {pinOverride, 1.5, 0.0}, {darkOverride, 0.0, -1.0}} {
+		if o.set(request, now) == nil {
+			t.Errorf("invalid override %v accepted.", request)
+		}
+	}
+}
+
+func TestServeOverride(t *testing.T) {
+	config := defaultConfiguration()
+	config.OperatorToken = "operator"
+	d, _ := newTestWebDendrite(config)
+	routes := d.routes()
+
+	r := httptest.NewRequest("POST", "/override", strings.NewReader(`{"Command": "dark"}`))
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("override applied without the operator token.")
+	}
+
+	r = httptest.NewRequest("POST", "/override", strings.NewReader(`{"Command": "dark", "Duration": 30}`))
+	r.Header.Set("Authorization", "Bearer operator")
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("override rejected with status %d.", w.Code)
+	}
+
+	if _, held := d.overrides.next(time.Now()); held == nil || held.Command != darkOverride {
+		t.Errorf("neurone not held dark by the override.")
+	}
+
+	r = httptest.NewRequest("DELETE", "/override", nil)
+	r.Header.Set("Authorization", "Bearer operator")
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if _, held := d.overrides.next(time.Now()); w.Code != http.StatusNoContent || held != nil {
+		t.Errorf("override not cleared.")
+	}
+}
+
+func TestOverrideFirings(t *testing.T) {
+	config := defaultConfiguration()
+	m := newMonitor(config)
+	neurone := Neurone{0.5, nil, 0.0, time.Now().UnixNano(), config, nil, m}
+
+	state, neurone := applyOverride(cooldownOverride, neurone, nil)
+	m.update(stateName(state), neurone.energy, time.Now())
+	if m.status(time.Now()).LastFired != nil || m.firings != 0 {
+		t.Errorf("cooldown override counted as a firing.")
+	}
+
+	state, neurone = applyOverride(fireOverride, neurone, nil)
+	m.update(stateName(state), neurone.energy, time.Now())
+	if m.status(time.Now()).LastFired == nil || m.firings != 1 {
+		t.Errorf("firing during the cooldown not counted.")
+	}
+}
+
+func TestOverridesAfterStartup(t *testing.T) {
+	if !startingUp(wait) || !startingUp(startup) || startingUp(accumulate) || startingUp(held) {
+		t.Errorf("overrides not held back until the neurone has started up.")
+	}
+}
+
+func TestWaitWithoutInputs(t *testing.T) {
+	config := defaultConfiguration()
+	inputs := newInputBus(newLiveConfiguration(config, ""), nil)
+
+	// A neurone that hears nothing from the master or its dendrites still returns to the axon, so overrides
+	// are applied, and gives up waiting once the wait times out.
+	start := time.Now().Add(-time.Duration(waitTimeout+1.0) * time.Second).UnixNano()
+	neurone := Neurone{-2.0, inputs, waitLength, start, config, nil, nil}
+
+	finished := make(chan string, 1)
+	go func() {
+		state, _ := wait(neurone, nil)
+		finished <- stateName(state)
+	}()
+
+	select {
+	case state := <-finished:
+		if state != "accumulate" {
+			t.Errorf("neurone entered %s instead of accumulate after the wait timed out", state)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("neurone blocked waiting for an input.")
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// orbColour is the colour of the orb at full brightness when no palette is configured, as red, green and blue
+// components between 0.0 and 1.0.
+var orbColour = [3]float32{1.0, 0.75, 0.3}
+
+// stateNames maps each arduino command to the name of the state it animates, as used by the palettes in the
+// configuration.
+var stateNames = map[byte]string{'e': "accumulate", 'c': "cooldown", 'p': "powerup", 's': "startup"}
+
+// look holds the colour palettes, transfer curve and gamma correction that turn the brightness of the orb into
+// the values sent to a lighting device.
+type look struct {
+	palettes map[string][][3]float32
+	gamma    float64
+	curve    func(level float64) float64
+	role     string
+	scale    float32
+	watts    float64
+	power    *powerLimiter
+	ambient  *ambientLight
+}
+
+// newLook validates the lighting configuration and creates a look from it. Returns an error if the
+// configuration is invalid.
+func newLook(config LightingConfiguration) (*look, error) {
+	if config.Gamma <= 0.0 {
+		return nil, errors.New("gamma must be greater than zero")
+	}
+
+	for state, palette := range config.Palettes {
+		if len(palette) == 0 {
+			return nil, fmt.Errorf("palette for %s has no colours", state)
+		}
+	}
+
+	curve, err := newCurve(config.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return &look{config.Palettes, config.Gamma, curve, "", 1.0, 0.0, nil, nil}, nil
+}
+
+// forDevice returns a copy of the look for the nominated lighting device, using the palettes for the role of
+// the device and scaled to the brightness of the device.
+func (l *look) forDevice(device DeviceConfiguration) *look {
+	deviceLook := *l
+	deviceLook.role = device.Role
+	deviceLook.scale = device.Scale
+	deviceLook.watts = device.Watts
+
+	return &deviceLook
+}
+
+// newCurve creates the transfer function from energy to brightness described by the configuration. A "linear"
+// curve leaves the energy untouched, an "exponential" curve makes the orb stay dim until it nears full energy,
+// and a "points" curve is interpolated between the configured pairs of energy and brightness.
+func newCurve(config CurveConfiguration) (func(level float64) float64, error) {
+	switch config.Type {
+	case "", "linear":
+		return func(level float64) float64 { return level }, nil
+
+	case "exponential":
+		k := config.Exponent
+		if k == 0.0 {
+			return nil, errors.New("exponential curve needs a non-zero exponent")
+		}
+
+		return func(level float64) float64 { return (math.Exp(k*level) - 1.0) / (math.Exp(k) - 1.0) }, nil
+
+	case "points":
+		if len(config.Points) < 2 {
+			return nil, errors.New("points curve needs at least two points")
+		}
+
+		points := make([][2]float64, len(config.Points))
+		copy(points, config.Points)
+		sort.Slice(points, func(i, j int) bool { return points[i][0] < points[j][0] })
+
+		return func(level float64) float64 { return interpolatePoints(points, level) }, nil
+	}
+
+	return nil, fmt.Errorf("unknown brightness curve '%s'", config.Type)
+}
+
+// interpolatePoints linearly interpolates between the nominated points, which must be sorted by x. Levels
+// outside the points take the value of the nearest point.
+func interpolatePoints(points [][2]float64, level float64) float64 {
+	if level <= points[0][0] {
+		return points[0][1]
+	}
+
+	for i := 1; i < len(points); i++ {
+		if level <= points[i][0] {
+			a, b := points[i-1], points[i]
+			if b[0] == a[0] {
+				return b[1]
+			}
+
+			return a[1] + (b[1]-a[1])*(level-a[0])/(b[0]-a[0])
+		}
+	}
+
+	return points[len(points)-1][1]
+}
+
+// brightness applies the transfer curve, the brightness scale of the device, compensation for the ambient light
+// and any power limit to a level between 0.0 and 1.0.
+func (l *look) brightness(level float32) float32 {
+	return clampLevel(float32(l.curve(float64(clampLevel(level)))) * l.scale * l.ambient.scale() *
+		l.power.scale())
+}
+
+// reportPower estimates the power drawn by the device when lit at the nominated level, before any power limit
+// is applied, and reports it to the power limiter.
+func (l *look) reportPower(level float32) {
+	brightness := clampLevel(float32(l.curve(float64(clampLevel(level)))) * l.scale * l.ambient.scale())
+	l.power.report(l, l.watts*float64(brightness))
+}
+
+// colour returns the colour of the orb in the nominated state at full brightness. The palette for the state is
+// treated as a gradient, running from the first colour at a level of 0.0 to the last colour at 1.0.
+func (l *look) colour(command byte, level float32) [3]float32 {
+	palette, found := l.palettes[l.role+"."+stateNames[command]]
+	if !found {
+		palette, found = l.palettes[stateNames[command]]
+	}
+
+	if !found {
+		return orbColour
+	}
+
+	if len(palette) == 1 {
+		return palette[0]
+	}
+
+	position := float64(clampLevel(level)) * float64(len(palette)-1)
+	i := int(math.Min(math.Floor(position), float64(len(palette)-2)))
+	t := float32(position - float64(i))
+
+	var c [3]float32
+	for j := range c {
+		c[j] = palette[i][j] + (palette[i+1][j]-palette[i][j])*t
+	}
+
+	return c
+}
+
+// channels converts the level of the orb in the nominated state into gamma corrected 8 bit red, green and blue
+// channel values.
+func (l *look) channels(command byte, level float32) []byte {
+	colour := l.colour(command, level)
+	brightness := l.brightness(level)
+
+	channels := make([]byte, len(colour))
+	for i, c := range colour {
+		v := math.Pow(float64(clampLevel(c*brightness)), l.gamma)
+		channels[i] = byte(math.Floor(v*255.0 + 0.5))
+	}
+
+	return channels
+}
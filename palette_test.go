@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestBrightnessCurves(t *testing.T) {
+	linear, _ := newLook(LightingConfiguration{nil, 1.0, CurveConfiguration{"linear", 0.0, nil}})
+	if linear.brightness(0.3) != 0.3 {
+		t.Errorf("linear curve should not change the brightness.")
+	}
+
+	exponential, _ := newLook(LightingConfiguration{nil, 1.0, CurveConfiguration{"exponential", 3.0, nil}})
+	if exponential.brightness(0.0) != 0.0 || exponential.brightness(1.0) != 1.0 {
+		t.Errorf("exponential curve should run from dark to full brightness.")
+	}
+
+	if exponential.brightness(0.5) >= 0.5 {
+		t.Errorf("exponential curve should keep the orb dim at half energy.")
+	}
+
+	points := [][2]float64{{1.0, 1.0}, {0.0, 0.0}, {0.5, 0.8}}
+	piecewise, _ := newLook(LightingConfiguration{nil, 1.0, CurveConfiguration{"points", 0.0, points}})
+	if math.Abs(float64(piecewise.brightness(0.25))-0.4) > 0.0001 {
+		t.Errorf("points curve should interpolate between the configured points.")
+	}
+
+	_, err := newLook(LightingConfiguration{nil, 1.0, CurveConfiguration{"sine", 0.0, nil}})
+	if err == nil {
+		t.Errorf("error not raised for an unknown brightness curve.")
+	}
+}
+
+func TestPalettes(t *testing.T) {
+	palettes := map[string][][3]float32{"cooldown": {{1.0, 0.0, 0.0}, {0.0, 0.0, 1.0}}}
+	l, err := newLook(LightingConfiguration{palettes, 1.0, CurveConfiguration{"linear", 0.0, nil}})
+	if err != nil {
+		t.Fatalf("returned error for a valid lighting configuration.")
+	}
+
+	if !bytes.Equal(l.channels('c', 1.0), []byte{0, 0, 255}) {
+		t.Errorf("cooldown palette should end with the last colour.")
+	}
+
+	if !bytes.Equal(l.channels('c', 0.5), []byte{64, 0, 64}) {
+		t.Errorf("cooldown palette should blend colours at half brightness.")
+	}
+
+	if l.colour('e', 1.0) != orbColour {
+		t.Errorf("states without a palette should use the orb colour.")
+	}
+}
+
+func TestGamma(t *testing.T) {
+	l, _ := newLook(LightingConfiguration{nil, 2.0, CurveConfiguration{"linear", 0.0, nil}})
+	if l.channels('e', 0.5)[0] != 64 {
+		t.Errorf("gamma correction not applied to the colour channels.")
+	}
+}
+
+func TestDeviceLook(t *testing.T) {
+	palettes := map[string][][3]float32{"cooldown": {{1.0, 0.0, 0.0}}, "outer.cooldown": {{0.0, 1.0, 0.0}}}
+	l, _ := newLook(LightingConfiguration{palettes, 1.0, CurveConfiguration{"linear", 0.0, nil}})
+
+	inner := l.forDevice(DeviceConfiguration{"inner", 0.5, 0.0})
+	if !bytes.Equal(inner.channels('c', 1.0), []byte{128, 0, 0}) {
+		t.Errorf("device brightness scale not applied.")
+	}
+
+	outer := l.forDevice(DeviceConfiguration{"outer", 1.0, 0.0})
+	if !bytes.Equal(outer.channels('c', 1.0), []byte{0, 255, 0}) {
+		t.Errorf("palette for the role of the device not used.")
+	}
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	timestampHeader = "X-Neurone-Timestamp"
+	nonceHeader     = "X-Neurone-Nonce"
+	signatureHeader = "X-Neurone-Signature"
+
+	signatureWindow = 30 * time.Second
+)
+
+var (
+	errPeerNotAllowed   = errors.New("peer not allowed")
+	errNoCertificate    = errors.New("client certificate required")
+	errUnsigned         = errors.New("request not signed")
+	errStale            = errors.New("request timestamp outside the signature window")
+	errReplayed         = errors.New("request nonce already used")
+	errInvalidSignature = errors.New("invalid request signature")
+)
+
+// signature returns the HMAC of a request sent between neurones, covering the method, URI, timestamp, nonce and
+// body of the request.
+func signature(secret []byte, method string, uri string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, part := range []string{method, uri, timestamp, nonce} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{'\n'})
+	}
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// peerClient makes the requests sent by the neurone to the other neurones in the cluster, signing each of them
+// with the shared secret of the cluster.
+type peerClient struct {
+	client  http.Client
+	secret  []byte
+	secure  bool
+	monitor *monitor
+}
+
+// newPeerClient creates a client for the cluster described by the configuration. Requests are left unsigned
+// when there is no shared secret, and are sent over TLS when it is enabled. Returns an error if the
+// certificates of the neurone can't be loaded. The delivery of each firing is recorded by the monitor.
+func newPeerClient(config Configuration, monitor *monitor) (*peerClient, error) {
+	c := &peerClient{secret: []byte(config.SharedSecret), monitor: monitor}
+
+	tlsConfig, err := clientTLSConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		c.secure = true
+		c.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	return c, nil
+}
+
+// do sends a request with the nominated body to another neurone, giving up after the timeout.
+func (c *peerClient) do(method string, address string, body []byte, timeout time.Duration) (*http.Response,
+	error) {
+
+	request, err := http.NewRequest(method, address, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// Neurones listed with http addresses are upgraded once TLS is enabled across the cluster.
+	if c.secure && request.URL.Scheme == "http" {
+		request.URL.Scheme = "https"
+	}
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	if len(c.secret) > 0 {
+		nonce := make([]byte, 16)
+		rand.Read(nonce)
+
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(timestampHeader, timestamp)
+		request.Header.Set(nonceHeader, hex.EncodeToString(nonce))
+		request.Header.Set(signatureHeader, signature(c.secret, method, request.URL.RequestURI(), timestamp,
+			hex.EncodeToString(nonce), body))
+	}
+
+	client := c.client
+	client.Timeout = timeout
+	return client.Do(request)
+}
+
+// peerVerifier checks that requests received from other neurones come from an allowed peer, and are signed
+// with the shared secret of the cluster. Each nonce is only accepted once within the signature window. When TLS
+// is enabled, other neurones must also present a certificate signed by the certificate authority of the cluster.
+type peerVerifier struct {
+	mutex       sync.Mutex
+	secret      []byte
+	allowed     []*net.IPNet
+	nonces      map[string]time.Time
+	certificate bool
+}
+
+// newPeerVerifier creates a verifier for the cluster described by the configuration. Returns an error if one
+// of the allowed peers isn't an IP address or CIDR range.
+func newPeerVerifier(config Configuration) (*peerVerifier, error) {
+	v := &peerVerifier{secret: []byte(config.SharedSecret), nonces: map[string]time.Time{},
+		certificate: config.TLS.CA != ""}
+
+	for _, peer := range config.AllowedPeers {
+		_, network, err := net.ParseCIDR(peer)
+		if err != nil {
+			ip := net.ParseIP(peer)
+			if ip == nil {
+				return nil, errors.New("invalid allowed peer '" + peer + "'")
+			}
+
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		}
+
+		v.allowed = append(v.allowed, network)
+	}
+
+	return v, nil
+}
+
+// signed returns true if the verifier only accepts requests signed with the shared secret of the cluster.
+func (v *peerVerifier) signed() bool {
+	return len(v.secret) > 0
+}
+
+// verify returns an error if the request didn't come from an allowed peer, or isn't correctly signed. The body
+// of the request is left in place to be read by the handler.
+func (v *peerVerifier) verify(r *http.Request, now time.Time) error {
+	if v.certificate && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return errNoCertificate
+	}
+
+	if len(v.allowed) > 0 && !v.allowedPeer(r.RemoteAddr) {
+		return errPeerNotAllowed
+	}
+
+	if len(v.secret) == 0 {
+		return nil
+	}
+
+	timestamp := r.Header.Get(timestampHeader)
+	nonce := r.Header.Get(nonceHeader)
+	sig := r.Header.Get(signatureHeader)
+	if timestamp == "" || nonce == "" || sig == "" {
+		return errUnsigned
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errUnsigned
+	}
+
+	sent := time.Unix(seconds, 0)
+	if sent.Before(now.Add(-signatureWindow)) || sent.After(now.Add(signatureWindow)) {
+		return errStale
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expected := signature(v.secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return errInvalidSignature
+	}
+
+	return v.useNonce(nonce, now)
+}
+
+// allowedPeer returns true if the nominated remote address is one of the allowed peers.
+func (v *peerVerifier) allowedPeer(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range v.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// useNonce records the nonce of a verified request, returning an error if it has already been used. Nonces are
+// forgotten once they fall outside the signature window, as any request carrying them is then stale.
+func (v *peerVerifier) useNonce(nonce string, now time.Time) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for n, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, n)
+		}
+	}
+
+	if _, ok := v.nonces[nonce]; ok {
+		return errReplayed
+	}
+
+	v.nonces[nonce] = now.Add(2 * signatureWindow)
+	return nil
+}
+
+// wrap returns a handler that only passes verified requests on to the nominated handler.
+func (v *peerVerifier) wrap(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The body is read to check the signature, before anything is known about the sender.
+		if !limitBody(w, r) {
+			return
+		}
+
+		err := v.verify(r, time.Now())
+		if err != nil {
+			fmt.Printf("WARNING: Rejected %s %s from %s: %s\n", r.Method, r.URL.Path, r.RemoteAddr, err)
+		}
+
+		if err == errPeerNotAllowed || err == errNoCertificate {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		handler(w, r)
+	}
+}
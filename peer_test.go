@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedRequest returns a firing request signed with the nominated secret, nonce and timestamp.
+func signedRequest(secret string, nonce string, sent time.Time, body string) *http.Request {
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+
+	r := httptest.NewRequest("POST", "/v1/fire", strings.NewReader(body))
+	r.Header.Set(timestampHeader, timestamp)
+	r.Header.Set(nonceHeader, nonce)
+	r.Header.Set(signatureHeader, signature([]byte(secret), "POST", "/v1/fire", timestamp, nonce, []byte(body)))
+
+	return r
+}
+
+func TestPeerVerifier(t *testing.T) {
+	config := defaultConfiguration()
+	config.SharedSecret = "orbs"
+	v, _ := newPeerVerifier(config)
+	now := time.Now()
+
+	if err := v.verify(signedRequest("orbs", "a", now, "{}"), now); err != nil {
+		t.Errorf("signed request rejected: %s", err)
+	}
+
+	if err := v.verify(signedRequest("orbs", "a", now, "{}"), now); err != errReplayed {
+		t.Errorf("replayed request not rejected: %v", err)
+	}
+
+	if err := v.verify(httptest.NewRequest("GET", "/?e=5", nil), now); err != errUnsigned {
+		t.Errorf("unsigned request not rejected: %v", err)
+	}
+
+	if err := v.verify(signedRequest("orbs", "b", now.Add(-time.Minute), "{}"), now); err != errStale {
+		t.Errorf("stale request not rejected: %v", err)
+	}
+
+	if err := v.verify(signedRequest("visitor", "c", now, "{}"), now); err != errInvalidSignature {
+		t.Errorf("request signed with the wrong secret not rejected: %v", err)
+	}
+
+	r := signedRequest("orbs", "d", now, "{}")
+	r.Body = httptest.NewRequest("POST", "/", strings.NewReader(`{"Energy": 5}`)).Body
+	if err := v.verify(r, now); err != errInvalidSignature {
+		t.Errorf("tampered request not rejected: %v", err)
+	}
+}
+
+func TestAllowedPeers(t *testing.T) {
+	config := defaultConfiguration()
+	config.AllowedPeers = []string{"10.1.1.4", "10.1.2.0/24"}
+	v, err := newPeerVerifier(config)
+	if err != nil {
+		t.Fatalf("unable to parse allowed peers: %s", err)
+	}
+
+	for address, allowed := range map[string]bool{"10.1.1.4:1234": true, "10.1.2.9:1234": true,
+		"10.1.1.5:1234": false} {
+
+		r := httptest.NewRequest("GET", "/power", nil)
+		r.RemoteAddr = address
+		if (v.verify(r, time.Now()) == nil) != allowed {
+			t.Errorf("incorrectly verified request from %s", address)
+		}
+	}
+
+	config.AllowedPeers = []string{"orb"}
+	if _, err := newPeerVerifier(config); err == nil {
+		t.Errorf("invalid allowed peer accepted.")
+	}
+}
+
+func TestSignedFiring(t *testing.T) {
+	config := defaultConfiguration()
+	config.SharedSecret = "orbs"
+
+	d, _ := newTestWebDendrite(config)
+	server := httptest.NewServer(d.routes())
+	defer server.Close()
+
+	peers, _ := newPeerClient(config, nil)
+	if err := peers.fire(server.URL+"/", newImpulse("left", 0.5, "")); err != nil {
+		t.Errorf("signed impulse refused: %s", err)
+	}
+
+	config.SharedSecret = ""
+	peers, _ = newPeerClient(config, nil)
+	if err := peers.fire(server.URL+"/", newImpulse("visitor", 0.5, "")); err == nil {
+		t.Errorf("unsigned impulse accepted.")
+	}
+
+	response, err := http.Get(server.URL + "/?e=5")
+	if err != nil {
+		t.Fatalf("unable to send legacy fire request: %s", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unsigned legacy fire request accepted.")
+	}
+
+	if d.inputs.pending() != 1 {
+		t.Errorf("incorrect number of impulses %d reached the neurone", d.inputs.pending())
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	powerBalanceInterval = 1 * time.Second
+	powerRequestTimeout  = 500 * time.Millisecond
+	clusterScaleTimeout  = 5 * time.Second
+)
+
+// powerRequest is sent by the master to set the scale a neurone applies to keep the cluster within budget.
+type powerRequest struct {
+	Scale float64
+}
+
+// powerReport is the response of a neurone to the master when asked about its power draw.
+type powerReport struct {
+	Watts float64
+	Scale float64
+}
+
+// powerLimiter estimates the power drawn by the lighting devices of a neurone, and scales their brightness
+// down to keep within the power budget of the neurone and the budget set by the master for the whole cluster.
+type powerLimiter struct {
+	mutex          sync.Mutex
+	budget         float64
+	demands        map[*look]float64
+	clusterScale   float64
+	clusterExpires time.Time
+}
+
+// newPowerLimiter creates a limiter for a neurone with the nominated budget in watts. A budget of zero leaves
+// the power of the neurone unlimited.
+func newPowerLimiter(budget float64) *powerLimiter {
+	return &powerLimiter{budget: budget, demands: map[*look]float64{}, clusterScale: 1.0}
+}
+
+// report updates the power in watts that the nominated device would draw at full scale.
+func (p *powerLimiter) report(device *look, watts float64) {
+	if p == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.demands[device] = watts
+}
+
+// demand returns the power in watts that the lighting devices of the neurone would draw without any limits.
+func (p *powerLimiter) demand() float64 {
+	if p == nil {
+		return 0.0
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	total := 0.0
+	for _, watts := range p.demands {
+		total += watts
+	}
+
+	return total
+}
+
+// scale returns the amount, between 0.0 and 1.0, to scale the brightness of every lighting device by.
+func (p *powerLimiter) scale() float32 {
+	if p == nil {
+		return 1.0
+	}
+
+	demand := p.demand()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	scale := 1.0
+	if p.budget > 0.0 && demand > p.budget {
+		scale = p.budget / demand
+	}
+
+	// Fall back to the budget of the neurone if the master stops setting the cluster budget.
+	if time.Now().Before(p.clusterExpires) {
+		scale = math.Min(scale, p.clusterScale)
+	}
+
+	return float32(scale)
+}
+
+// setClusterScale sets the scale required by the master to keep the whole cluster within budget.
+func (p *powerLimiter) setClusterScale(scale float64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.clusterScale = math.Min(math.Max(scale, 0.0), 1.0)
+	p.clusterExpires = time.Now().Add(clusterScaleTimeout)
+}
+
+// powerAddress returns the address of the power endpoint for the nominated neurone.
+func powerAddress(address string) (string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = "/power"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// balancePowerOnce sends the current cluster scale to every neurone, collecting their power demand in return.
+// Returns the scale that keeps the whole cluster, including the master, within the cluster budget.
+func balancePowerOnce(config Configuration, power *powerLimiter, peers *peerClient, scale float64) float64 {
+	demands := make(chan float64, len(config.AllNeurones))
+
+	for _, neurone := range config.AllNeurones {
+		go func(address string) {
+			var report powerReport
+
+			address, err := powerAddress(address)
+			if err == nil {
+				var response *http.Response
+				body, _ := json.Marshal(powerRequest{scale})
+				response, err = peers.do("POST", address, body, powerRequestTimeout)
+				if err == nil {
+					err = json.NewDecoder(response.Body).Decode(&report)
+					response.Body.Close()
+				}
+			}
+
+			if err != nil {
+				fmt.Printf("WARNING: Unable to balance power with %s: %s\n", address, err)
+			}
+			demands <- report.Watts
+		}(neurone.Address)
+	}
+
+	total := power.demand()
+	for range config.AllNeurones {
+		total += <-demands
+	}
+
+	if total <= config.ClusterPowerBudget {
+		return 1.0
+	}
+
+	return config.ClusterPowerBudget / total
+}
+
+// balancePower runs on the master neurone, keeping the power drawn by the whole cluster within the cluster
+// budget in the live configuration. Neurones that are asked to scale down their brightness fall back to their
+// own budget if the master goes quiet, as they do when the cluster budget is set to zero. The other neurones
+// only accept the scale in signed requests, so the cluster needs a shared secret.
+func balancePower(live *liveConfiguration, power *powerLimiter, peers *peerClient) {
+	scale := 1.0
+	warned := false
+
+	for {
+		config := live.get()
+		if config.ClusterPowerBudget > 0.0 && config.SharedSecret == "" && !warned {
+			fmt.Printf("WARNING: The cluster power budget needs a shared secret, other neurones will refuse the scale\n")
+			warned = true
+		}
+
+		if config.ClusterPowerBudget > 0.0 {
+			scale = balancePowerOnce(config, power, peers, scale)
+			power.setClusterScale(scale)
+		}
+
+		time.Sleep(powerBalanceInterval)
+	}
+}
+
+// servePower handles requests for the power draw of this neurone. A GET request only reports the power draw,
+// while a POST request from the master also carries the cluster scale to apply. The scale is only accepted
+// from trusted requests, those signed with the shared secret or carrying the operator token.
+func servePower(power *powerLimiter, trusted bool, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if !trusted {
+			writeError(w, http.StatusForbidden, "power scale requires a signed request or the operator token")
+			return
+		}
+
+		var request powerRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || !finite(request.Scale) {
+			writeError(w, http.StatusBadRequest, "invalid scale")
+			return
+		}
+
+		power.setClusterScale(request.Scale)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(powerReport{power.demand(), float64(power.scale())})
+}
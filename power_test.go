@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPowerBudget(t *testing.T) {
+	power := newPowerLimiter(100.0)
+	l, _ := newLook(defaultConfiguration().Lighting)
+	l.power = power
+	inner := l.forDevice(DeviceConfiguration{"inner", 1.0, 80.0})
+	outer := l.forDevice(DeviceConfiguration{"outer", 1.0, 120.0})
+
+	inner.reportPower(0.5)
+	outer.reportPower(0.5)
+	if power.scale() != 1.0 {
+		t.Errorf("brightness scaled down while within the power budget.")
+	}
+
+	inner.reportPower(1.0)
+	outer.reportPower(1.0)
+	if power.scale() != 0.5 {
+		t.Errorf("brightness not scaled down to keep within the power budget.")
+	}
+
+	if inner.brightness(1.0) != 0.5 {
+		t.Errorf("power limit not applied to the brightness of the device.")
+	}
+}
+
+func TestClusterPowerBudget(t *testing.T) {
+	neurone := newPowerLimiter(0.0)
+	l, _ := newLook(defaultConfiguration().Lighting)
+	l.power = neurone
+	l.forDevice(DeviceConfiguration{"orb", 1.0, 300.0}).reportPower(1.0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servePower(neurone, true, w, r)
+	}))
+	defer server.Close()
+
+	master := newPowerLimiter(0.0)
+	l.power = master
+	l.forDevice(DeviceConfiguration{"orb", 1.0, 100.0}).reportPower(1.0)
+
+	config := defaultConfiguration()
+	config.AllNeurones = []AdjacentNeurone{{0.0, server.URL + "/"}}
+	config.ClusterPowerBudget = 200.0
+
+	peers, _ := newPeerClient(config, nil)
+	scale := balancePowerOnce(config, master, peers, 1.0)
+	if scale != 0.5 {
+		t.Errorf("incorrect cluster scale %f", scale)
+	}
+
+	balancePowerOnce(config, master, peers, scale)
+	if math.Abs(float64(neurone.scale())-0.5) > 0.0001 {
+		t.Errorf("cluster scale not applied to the neurone.")
+	}
+}
+
+func TestPowerScaleTrusted(t *testing.T) {
+	config := defaultConfiguration()
+	config.OperatorToken = "operator"
+	d, _ := newTestWebDendrite(config)
+
+	w := httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("GET", "/power?scale=0", nil))
+	if w.Code != http.StatusOK || d.power.scale() != 1.0 {
+		t.Errorf("power scale changed by a GET request.")
+	}
+
+	w = httptest.NewRecorder()
+	d.routes().ServeHTTP(w, httptest.NewRequest("POST", "/power", strings.NewReader(`{"Scale": 0}`)))
+	if w.Code != http.StatusForbidden || d.power.scale() != 1.0 {
+		t.Errorf("power scale changed by an unsigned request.")
+	}
+
+	r := httptest.NewRequest("POST", "/power", strings.NewReader(`{"Scale": 0.5}`))
+	r.Header.Set("Authorization", "Bearer operator")
+	w = httptest.NewRecorder()
+	d.routes().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || d.power.scale() != 0.5 {
+		t.Errorf("power scale from the operator not applied.")
+	}
+}
+
+func TestPowerupWithinBudget(t *testing.T) {
+	l, _ := newLook(defaultConfiguration().Lighting)
+	config := defaultConfiguration().Arduino
+	config.Watts = 100.0
+	config.MaxUpdateRate = 0.0
+
+	for _, budget := range []float64{0.0, 50.0} {
+		l.power = newPowerLimiter(budget)
+		device := l.forDevice(config.DeviceConfiguration)
+
+		port := newCommandLog()
+		arduino, _ := newArduinoOutput(port, config, device)
+		powerupArduino(arduino)
+
+		sent := port.sent()
+		if budget == 0.0 && (len(sent) != 1 || sent[0].command != 'p') {
+			t.Errorf("powerup flash not sent when the power is unlimited.")
+		}
+
+		if budget == 50.0 && (len(sent) != 1 || sent[0].command != 'e' || sent[0].argument != 0.5) {
+			t.Errorf("powerup flash not limited to the power budget %+v", sent)
+		}
+	}
+}
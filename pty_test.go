@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPtyRoundTrip(t *testing.T) {
+	master, slaveName, err := openPty()
+	if err != nil {
+		t.Skipf("pseudo-terminals unavailable: %s", err)
+	}
+	defer master.Close()
+
+	slave, err := os.OpenFile(slaveName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("unable to open %s: %s", slaveName, err)
+	}
+	defer slave.Close()
+
+	if err := makeRaw(slave); err != nil {
+		t.Fatalf("unable to put terminal in raw mode: %s", err)
+	}
+
+	// The command includes bytes a terminal would otherwise translate, such as a carriage return.
+	command := arduinoCommand('e', 0.5)
+	awkward := math.Float32frombits(binary.LittleEndian.Uint32([]byte{'\r', '\n', 0x03, 0x3f}))
+	command = append(command, arduinoCommand('c', awkward)...)
+
+	look, _ := newLook(defaultConfiguration().Lighting)
+	orb := newVirtualOrb(look)
+	received := make(chan int, 1)
+	go func() {
+		total := 0
+		buf := make([]byte, 64)
+		for total < len(command) {
+			n, err := master.Read(buf)
+			if err != nil {
+				break
+			}
+			orb.decoder.Write(buf[:n])
+			total += n
+		}
+		received <- total
+	}()
+
+	if _, err := slave.Write(command); err != nil {
+		t.Fatalf("unable to write to the virtual serial port: %s", err)
+	}
+
+	select {
+	case total := <-received:
+		if total != len(command) {
+			t.Errorf("received %d bytes instead of %d", total, len(command))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("commands didn't arrive at the virtual arduino.")
+	}
+
+	if command, _ := orb.animation.current(time.Now()); command != 'c' {
+		t.Errorf("virtual arduino decoded command %c instead of c", command)
+	}
+}
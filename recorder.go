@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/huin/goserial"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordedCommand is a single command sent to the arduino, along with the time that it was sent.
+type recordedCommand struct {
+	at       time.Time
+	command  byte
+	argument float32
+	frame    []byte
+}
+
+// String formats the command as a single line of a recording.
+func (r recordedCommand) String() string {
+	if r.command == 'f' {
+		return fmt.Sprintf("%s f %s", r.at.Format(time.RFC3339Nano), hex.EncodeToString(r.frame))
+	}
+
+	return fmt.Sprintf("%s %c %s", r.at.Format(time.RFC3339Nano), r.command,
+		strconv.FormatFloat(float64(r.argument), 'g', -1, 32))
+}
+
+// parseRecordedCommand parses a single line of a recording back into the command. Returns an error if the line
+// is malformed.
+func parseRecordedCommand(line string) (r recordedCommand, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || len(fields[1]) != 1 {
+		return r, fmt.Errorf("malformed recording '%s'", line)
+	}
+
+	r.at, err = time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return r, err
+	}
+
+	r.command = fields[1][0]
+	if r.command == 'f' {
+		r.frame, err = hex.DecodeString(fields[2])
+		if err == nil && len(r.frame)%3 != 0 {
+			err = errors.New("pixel frame is not a whole number of pixels")
+		}
+
+		return r, err
+	}
+
+	argument, err := strconv.ParseFloat(fields[2], 32)
+	r.argument = float32(argument)
+	return r, err
+}
+
+// serialRecorder wraps the serial connection to the arduino, writing each command sent down it to a recording
+// along with the time it was sent.
+type serialRecorder struct {
+	mutex     sync.Mutex
+	port      io.ReadWriteCloser
+	recording io.WriteCloser
+	decoder   commandDecoder
+}
+
+// newSerialRecorder creates a recorder that appends the commands sent over the nominated serial port to the
+// recording. Each run of the neurone starts a new session within the recording.
+func newSerialRecorder(port io.ReadWriteCloser, recording io.WriteCloser) *serialRecorder {
+	r := &serialRecorder{port: port, recording: recording}
+	r.decoder.handle = func(command byte, argument float32) error {
+		_, err := fmt.Fprintln(r.recording, recordedCommand{time.Now(), command, argument, nil})
+		return err
+	}
+	r.decoder.handleFrame = func(frame []byte) error {
+		_, err := fmt.Fprintln(r.recording, recordedCommand{time.Now(), 'f', 0.0, frame})
+		return err
+	}
+
+	fmt.Fprintf(recording, "# session %s\n", time.Now().Format(time.RFC3339Nano))
+	return r
+}
+
+func (r *serialRecorder) Read(p []byte) (n int, err error) {
+	return r.port.Read(p)
+}
+
+func (r *serialRecorder) Write(p []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	n, err = r.port.Write(p)
+	r.decoder.Write(p[:n])
+
+	return n, err
+}
+
+func (r *serialRecorder) Close() error {
+	r.recording.Close()
+	return r.port.Close()
+}
+
+// replay plays a recording back into an arduino (real or virtual), reproducing the timing between commands.
+// The speed multiplies the rate of playback, a speed of zero plays back the commands as fast as possible.
+// Sessions within the recording are played back to back.
+func replay(recording io.Reader, speed float64, serialPort io.ReadWriteCloser) error {
+	var last time.Time
+	scanner := bufio.NewScanner(recording)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// A new session restarts the clock, there is no need to wait out the time the neurone was stopped.
+		if strings.HasPrefix(line, "#") {
+			last = time.Time{}
+			continue
+		}
+
+		r, err := parseRecordedCommand(line)
+		if err != nil {
+			return err
+		}
+
+		if !last.IsZero() && speed > 0.0 {
+			time.Sleep(time.Duration(float64(r.at.Sub(last)) / speed))
+		}
+		last = r.at
+
+		if r.command == 'f' {
+			err = sendPixelFrame(r.frame, serialPort)
+		} else {
+			err = sendArduinoCommand(r.command, r.argument, serialPort)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// replaySerial is the command line entry point for replaying a recording into an arduino.
+func replaySerial(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	port := flags.String("port", "", "serial port of the arduino, searches /dev for the arduino when empty")
+	baud := flags.Int("baud", 9600, "baud rate of the serial port")
+	speed := flags.Float64("speed", 1.0, "playback speed, 2.0 plays twice as fast and 0.0 as fast as possible")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Printf("usage: replay [-port path] [-baud rate] [-speed multiplier] recording\n")
+		os.Exit(2)
+	}
+
+	recording, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Printf("ERROR: Unable to open recording: %s\n", err)
+		os.Exit(1)
+	}
+	defer recording.Close()
+
+	if *port == "" {
+		*port = findArduino()
+	}
+
+	s, err := goserial.OpenPort(&goserial.Config{Name: *port, Baud: *baud})
+	if err != nil {
+		fmt.Printf("ERROR: Unable to open serial port '%s': %s\n", *port, err)
+		os.Exit(1)
+	}
+	defer s.Close()
+
+	// When connecting to an older revision arduino, you need to wait a little while it resets.
+	time.Sleep(1 * time.Second)
+
+	fmt.Printf("Replaying %s into %s\n", flags.Arg(0), *port)
+	err = replay(recording, *speed, s)
+	if err != nil {
+		fmt.Printf("ERROR: Replay failed: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	sent := new(bytes.Buffer)
+	recording := new(bytes.Buffer)
+	recorder := newSerialRecorder(nopCloser{sent}, nopCloser{recording})
+
+	updateArduinoEnergy(0.123456, recorder)
+	sendPixelFrame([]byte{1, 2, 3}, recorder)
+	powerupArduino(recorder)
+	cooldownArduino(0.5, recorder)
+
+	replayed := new(bytes.Buffer)
+	err := replay(bytes.NewReader(recording.Bytes()), 0.0, nopCloser{replayed})
+	if err != nil {
+		t.Fatalf("returned error when replaying a valid recording: %s", err)
+	}
+
+	if !bytes.Equal(sent.Bytes(), replayed.Bytes()) {
+		t.Errorf("replayed commands differ from the recorded commands.")
+	}
+}
+
+func TestMalformedRecording(t *testing.T) {
+	err := replay(bytes.NewBufferString("yesterday e 0.5\n"), 0.0, nopCloser{new(bytes.Buffer)})
+	if err == nil {
+		t.Errorf("error not raised for a malformed recording.")
+	}
+}
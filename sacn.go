@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	sacnPort           = "5568"
+	sacnMaxUniverse    = 63999
+	sacnMaxPriority    = 200
+	sacnSourceNameSize = 64
+	sacnRootVector     = 0x00000004
+	sacnFrameVector    = 0x00000002
+	sacnDMPVector      = 0x02
+)
+
+// sacn transmits the brightness of the orb to a lighting desk or DMX controller as E1.31 data packets.
+type sacn struct {
+	conn       net.Conn
+	cid        [16]byte
+	sourceName string
+	priority   uint8
+	universe   uint16
+	channel    int
+	sequence   uint8
+}
+
+// newSACNOutput creates a lighting output that publishes the orb as a DMX universe over E1.31. Packets are
+// multicast to the universe unless an Address is nominated in the configuration, in which case they are
+// sent unicast. Returns an error if the configuration is invalid or the address can't be resolved.
+func newSACNOutput(config SACNConfiguration, look *look) (io.ReadWriteCloser, error) {
+	if config.Universe < 1 || config.Universe > sacnMaxUniverse {
+		return nil, errors.New("sACN universe out of range")
+	}
+
+	if config.Priority > sacnMaxPriority {
+		return nil, errors.New("sACN priority out of range")
+	}
+
+	if !validDMXChannel(config.Channel) {
+		return nil, errors.New("DMX channel out of range")
+	}
+
+	address := config.Address
+	if address == "" {
+		address = fmt.Sprintf("239.255.%d.%d", config.Universe>>8, config.Universe&0xff)
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, sacnPort)
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sacn{conn: conn, sourceName: config.SourceName, priority: config.Priority,
+		universe: config.Universe, channel: config.Channel}
+
+	// Derive the component identifier from the source name, so the lighting desk sees the same source each
+	// time the neurone restarts.
+	hash := sha1.Sum([]byte("gasworks-neurone:" + config.SourceName))
+	copy(s.cid[:], hash[:])
+	s.cid[6] = (s.cid[6] & 0x0f) | 0x50
+	s.cid[8] = (s.cid[8] & 0x3f) | 0x80
+
+	return newAnimatedOutput(fmt.Sprintf("sacn %d", config.Universe), dmxFrameRate, look, s.draw, conn), nil
+}
+
+// draw sends a single DMX frame lighting the orb as it appears at the nominated time.
+func (s *sacn) draw(animation *orbAnimation, now time.Time) error {
+	s.sequence++
+
+	_, err := s.conn.Write(sacnDataPacket(s.cid, s.sourceName, s.priority, s.sequence, s.universe,
+		dmxUniverse(s.channel, animation.channels(now))))
+	return err
+}
+
+// sacnDataPacket packages the DMX channel data for the nominated universe into an E1.31 data packet.
+func sacnDataPacket(cid [16]byte, sourceName string, priority uint8, sequence uint8, universe uint16,
+	data []byte) []byte {
+
+	length := 126 + len(data)
+	packet := new(bytes.Buffer)
+
+	// Root layer.
+	binary.Write(packet, binary.BigEndian, uint16(0x0010)) // Preamble size.
+	binary.Write(packet, binary.BigEndian, uint16(0x0000)) // Postamble size.
+	packet.WriteString("ASC-E1.17\x00\x00\x00")
+	binary.Write(packet, binary.BigEndian, uint16(0x7000|(length-16)))
+	binary.Write(packet, binary.BigEndian, uint32(sacnRootVector))
+	packet.Write(cid[:])
+
+	// Framing layer.
+	name := make([]byte, sacnSourceNameSize)
+	copy(name[:sacnSourceNameSize-1], sourceName)
+
+	binary.Write(packet, binary.BigEndian, uint16(0x7000|(length-38)))
+	binary.Write(packet, binary.BigEndian, uint32(sacnFrameVector))
+	packet.Write(name)
+	packet.WriteByte(priority)
+	binary.Write(packet, binary.BigEndian, uint16(0)) // Synchronization address.
+	packet.WriteByte(sequence)
+	packet.WriteByte(0) // Options.
+	binary.Write(packet, binary.BigEndian, universe)
+
+	// Device management protocol layer.
+	binary.Write(packet, binary.BigEndian, uint16(0x7000|(length-115)))
+	packet.WriteByte(sacnDMPVector)
+	packet.WriteByte(0xa1)                                 // Address type and data type.
+	binary.Write(packet, binary.BigEndian, uint16(0x0000)) // First property address.
+	binary.Write(packet, binary.BigEndian, uint16(0x0001)) // Address increment.
+	binary.Write(packet, binary.BigEndian, uint16(len(data)+1))
+	packet.WriteByte(0) // DMX start code.
+	packet.Write(data)
+
+	return packet.Bytes()
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSACNOutput(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to open local UDP listener.")
+	}
+	defer listener.Close()
+
+	look, _ := newLook(defaultConfiguration().Lighting)
+	output, err := newSACNOutput(SACNConfiguration{listener.LocalAddr().String(), 7, 1, 150, "Test orb", DeviceConfiguration{"test", 1.0, 0.0}}, look)
+	if err != nil {
+		t.Fatalf("unable to create sACN output: %s", err)
+	}
+	defer output.Close()
+
+	packet := make([]byte, 1024)
+	listener.SetReadDeadline(time.Now().Add(1 * time.Second))
+	n, _, err := listener.ReadFrom(packet)
+	if err != nil {
+		t.Fatalf("did not receive an sACN packet.")
+	}
+
+	if n != 126+dmxChannels {
+		t.Fatalf("incorrect sACN packet length %d", n)
+	}
+
+	if !bytes.Equal(packet[4:16], []byte("ASC-E1.17\x00\x00\x00")) {
+		t.Errorf("incorrect ACN packet identifier.")
+	}
+
+	if !bytes.HasPrefix(packet[44:108], []byte("Test orb\x00")) {
+		t.Errorf("incorrect sACN source name.")
+	}
+
+	if packet[108] != 150 {
+		t.Errorf("incorrect sACN priority.")
+	}
+
+	if packet[113] != 0 || packet[114] != 7 {
+		t.Errorf("incorrect sACN universe.")
+	}
+}
+
+func TestSACNInvalidUniverse(t *testing.T) {
+	_, err := newSACNOutput(SACNConfiguration{"127.0.0.1", 0, 1, 100, "", DeviceConfiguration{"test", 1.0, 0.0}}, nil)
+	if err == nil {
+		t.Errorf("error not raised for an invalid sACN universe.")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// outputScheduler sits in front of a slow serial connection to the arduino and stops it from being flooded
+// with updates. Updates are coalesced, so only the latest is sent, and the rate of sending is capped. Priority
+// commands (such as powerup) jump ahead of any pending update and replace it.
+type outputScheduler struct {
+	mutex    sync.Mutex
+	port     io.ReadWriteCloser
+	decoder  commandDecoder
+	interval time.Duration
+	priority []recordedCommand
+	pending  *recordedCommand
+	warnings sendWarnings
+	wake     chan bool
+	done     chan bool
+	finished chan bool
+}
+
+// isPriorityCommand returns true if the nominated command must never be coalesced with other updates.
+func isPriorityCommand(command byte) bool {
+	return command == 'p'
+}
+
+// newOutputScheduler creates a scheduler that sends at most maxRate commands per second down the nominated port.
+// Failed sends are reported as warnings for the named output.
+func newOutputScheduler(port io.ReadWriteCloser, name string, maxRate float64) *outputScheduler {
+	s := &outputScheduler{port: port, interval: time.Duration(float64(time.Second) / maxRate),
+		warnings: sendWarnings{output: name}, wake: make(chan bool, 1), done: make(chan bool),
+		finished: make(chan bool)}
+
+	s.decoder.handle = func(command byte, argument float32) error {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		update := recordedCommand{time.Now(), command, argument, nil}
+		if isPriorityCommand(command) {
+			s.priority = append(s.priority, update)
+			s.pending = nil
+		} else {
+			s.pending = &update
+		}
+
+		select {
+		case s.wake <- true:
+		default:
+		}
+
+		return nil
+	}
+
+	go s.run()
+	return s
+}
+
+// next removes the next command to send from the queue. Returns nil if there is nothing left to send.
+func (s *outputScheduler) next() *recordedCommand {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.priority) > 0 {
+		update := s.priority[0]
+		s.priority = s.priority[1:]
+		return &update
+	}
+
+	update := s.pending
+	s.pending = nil
+	return update
+}
+
+// run sends queued commands down the serial port, waiting at least the scheduler interval between each one.
+func (s *outputScheduler) run() {
+	defer close(s.finished)
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+
+		for update := s.next(); update != nil; update = s.next() {
+			s.warnings.check(sendArduinoCommand(update.command, update.argument, s.port), time.Now())
+
+			select {
+			case <-s.done:
+				return
+			case <-time.After(s.interval):
+			}
+		}
+	}
+}
+
+func (s *outputScheduler) Read(p []byte) (n int, err error) {
+	return s.port.Read(p)
+}
+
+func (s *outputScheduler) Write(p []byte) (n int, err error) {
+	return s.decoder.Write(p)
+}
+
+func (s *outputScheduler) Close() error {
+	close(s.done)
+	<-s.finished
+
+	return s.port.Close()
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// commandLog is a serial port that records the commands written down it.
+type commandLog struct {
+	mutex    sync.Mutex
+	decoder  commandDecoder
+	commands []recordedCommand
+}
+
+func newCommandLog() *commandLog {
+	l := &commandLog{}
+	l.decoder.handle = func(command byte, argument float32) error {
+		l.commands = append(l.commands, recordedCommand{time.Now(), command, argument, nil})
+		return nil
+	}
+
+	return l
+}
+
+func (l *commandLog) Read(p []byte) (n int, err error) {
+	return 0, nil
+}
+
+func (l *commandLog) Write(p []byte) (n int, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.decoder.Write(p)
+}
+
+func (l *commandLog) Close() error {
+	return nil
+}
+
+func (l *commandLog) sent() []recordedCommand {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]recordedCommand{}, l.commands...)
+}
+
+// waitForCommand polls the port until a command is sent that satisfies the check, returning every command
+// sent so far. The test fails if no such command is sent within a second.
+func waitForCommand(t *testing.T, port *commandLog, check func(sent recordedCommand) bool) []recordedCommand {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		sent := port.sent()
+		for _, command := range sent {
+			if check(command) {
+				return sent
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("expected command was not sent %+v", port.sent())
+	return nil
+}
+
+func TestOutputScheduler(t *testing.T) {
+	port := newCommandLog()
+	scheduler := newOutputScheduler(port, "arduino", 20.0)
+	defer scheduler.Close()
+
+	// Flood the scheduler with energy updates, followed by a powerup.
+	flooded := time.Now()
+	for i := 1; i <= 100; i++ {
+		updateArduinoEnergy(float32(i)/100.0, scheduler)
+	}
+	powerupArduino(scheduler)
+	flood := time.Since(flooded)
+
+	waitForCommand(t, port, func(sent recordedCommand) bool {
+		return sent.command == 'p'
+	})
+
+	cooldownArduino(0.5, scheduler)
+	sent := waitForCommand(t, port, func(sent recordedCommand) bool {
+		return sent.command == 'c'
+	})
+
+	// An energy update is sent each time the scheduler wakes during the flood, and may be sent before the
+	// flood is coalesced.
+	wakes := int(flood/scheduler.interval) + 1
+	if len(sent) > 2+wakes || len(sent) < 2 {
+		t.Fatalf("updates were not coalesced, %d commands sent.", len(sent))
+	}
+
+	last, powerup := sent[len(sent)-1], sent[len(sent)-2]
+	if powerup.command != 'p' || last.command != 'c' {
+		t.Errorf("powerup did not replace the pending energy update.")
+	}
+
+	if last.argument != 0.5 {
+		t.Errorf("latest update was not sent.")
+	}
+
+	if last.at.Sub(powerup.at) < 45*time.Millisecond {
+		t.Errorf("send rate was not capped.")
+	}
+}
+
+func TestSendWarnings(t *testing.T) {
+	warnings := sendWarnings{output: "arduino"}
+	now := time.Now()
+
+	warnings.check(nil, now)
+	warnings.check(errors.New("unplugged"), now)
+	warnings.check(errors.New("unplugged"), now.Add(time.Second))
+	if warnings.missed != 1 || !warnings.last.Equal(now) {
+		t.Errorf("failed sends not limited to one warning each interval.")
+	}
+
+	warnings.check(errors.New("unplugged"), now.Add(sendWarningInterval))
+	if warnings.missed != 0 || !warnings.last.Equal(now.Add(sendWarningInterval)) {
+		t.Errorf("failed send not reported after the interval.")
+	}
+}
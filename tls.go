@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// loadCertificatePool reads the PEM encoded certificate of the certificate authority for the cluster.
+func loadCertificatePool(caFile string) (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in " + caFile)
+	}
+
+	return pool, nil
+}
+
+// loadTLS reads the certificate authority, certificate and key of the neurone nominated in the configuration.
+func loadTLS(config TLSConfiguration) (*x509.CertPool, tls.Certificate, error) {
+	pool, err := loadCertificatePool(config.CA)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(config.Certificate, config.Key)
+	return pool, certificate, err
+}
+
+// serverTLSConfig returns the TLS configuration for the web dendrite. Certificates presented by clients must be
+// signed by the certificate authority of the cluster, but browsers and Prometheus may connect without one. The
+// peer verifier requires a certificate on the requests between neurones. Returns nil when TLS is disabled.
+func serverTLSConfig(config TLSConfiguration) (*tls.Config, error) {
+	if config.CA == "" {
+		return nil, nil
+	}
+
+	pool, certificate, err := loadTLS(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// clientTLSConfig returns the TLS configuration for requests sent to other neurones, which present the
+// certificate of this neurone and only trust neurones signed by the certificate authority of the cluster.
+// Returns nil when TLS is disabled.
+func clientTLSConfig(config TLSConfiguration) (*tls.Config, error) {
+	if config.CA == "" {
+		return nil, nil
+	}
+
+	pool, certificate, err := loadTLS(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeNeuroneCertificate issues a certificate for a neurone on the local host, returning the TLS
+// configuration that uses it.
+func writeNeuroneCertificate(t *testing.T, dir string, ca *certificateAuthority, name string) TLSConfiguration {
+	certificate, key, err := ca.issue(name, []string{"127.0.0.1", "localhost"})
+	if err != nil {
+		t.Fatalf("unable to issue certificate: %s", err)
+	}
+
+	config := TLSConfiguration{filepath.Join(dir, "ca.pem"), filepath.Join(dir, name+".pem"),
+		filepath.Join(dir, name+"-key.pem")}
+	ioutil.WriteFile(config.Certificate, certificate, 0644)
+	ioutil.WriteFile(config.Key, key, 0600)
+
+	return config
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "neurone-tls")
+	if err != nil {
+		t.Fatalf("unable to create certificate directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := newCertificateAuthority("test")
+	if err != nil {
+		t.Fatalf("unable to create certificate authority: %s", err)
+	}
+	ca.save(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+
+	loaded, err := loadCertificateAuthority(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem"))
+	if err != nil || !loaded.certificate.Equal(ca.certificate) {
+		t.Fatalf("unable to reload certificate authority: %s", err)
+	}
+
+	config := defaultConfiguration()
+	config.ListenAddress = "127.0.0.1:0"
+	config.TLS = writeNeuroneCertificate(t, dir, loaded, "right")
+
+	d, err := newTestWebDendrite(config)
+	if err != nil {
+		t.Fatalf("unable to create web dendrite: %s", err)
+	}
+
+	if err := d.Start(); err != nil {
+		t.Fatalf("unable to start web dendrite: %s", err)
+	}
+	defer d.Stop()
+
+	config.TLS = writeNeuroneCertificate(t, dir, loaded, "left")
+	peers, err := newPeerClient(config, nil)
+	if err != nil {
+		t.Fatalf("unable to create TLS client: %s", err)
+	}
+
+	// Addresses in the configuration still use http, and are upgraded by the client.
+	if err := peers.fire("http://"+d.Addr().String()+"/", newImpulse("left", 0.5, "")); err != nil {
+		t.Errorf("impulse over TLS refused: %s", err)
+	}
+
+	if d.inputs.pending() != 1 {
+		t.Errorf("impulse over TLS didn't reach the neurone.")
+	}
+
+	// A client that trusts the neurone but doesn't present a certificate of its own is turned away.
+	pool, _ := loadCertificatePool(config.TLS.CA)
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	response, err := client.Get("https://" + d.Addr().String() + "/power")
+	if err != nil {
+		t.Fatalf("unable to connect without a client certificate: %s", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("request from a neurone without a client certificate accepted.")
+	}
+
+	// Browsers and Prometheus reach the read-only endpoints without a certificate.
+	for _, path := range []string{"/status", "/metrics"} {
+		response, err := client.Get("https://" + d.Addr().String() + path)
+		if err != nil {
+			t.Fatalf("unable to reach %s without a client certificate: %s", path, err)
+		}
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("%s refused without a client certificate: %s", path, response.Status)
+		}
+	}
+}
+
+func TestCertificateNames(t *testing.T) {
+	for name, valid := range map[string]bool{"orb1": true, "ca": false, "ca-key": false, "../orb": false,
+		".orb": false} {
+
+		if (validCertificateName(name) == nil) != valid {
+			t.Errorf("incorrectly validated certificate name '%s'", name)
+		}
+	}
+}
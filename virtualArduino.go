@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	virtualFrameRate = 15
+	virtualBarWidth  = 30
+	virtualMaxPixels = 60
+	frameTimeout     = 1 * time.Second
+)
+
+// virtualOrb is an emulated arduino. It decodes the commands sent over serial and plays the same animations
+// as the firmware, or displays the pixel frames streamed to it. The neurone has already applied the curve of its
+// look to the commands, and the firmware ignores the palettes, so the animations are played with an identity
+// look. The look of the neurone only picks the colour shown in the terminal.
+type virtualOrb struct {
+	decoder   commandDecoder
+	animation orbAnimation
+	look      *look
+	mutex     sync.Mutex
+	frame     []byte
+	frameTime time.Time
+}
+
+// newVirtualOrb creates an emulated arduino, shown in the terminal with the colours of the nominated look.
+func newVirtualOrb(display *look) *virtualOrb {
+	identity, _ := newLook(defaultConfiguration().Lighting)
+
+	orb := &virtualOrb{look: display}
+	orb.animation.look = identity
+	orb.decoder.handle = func(command byte, argument float32) error {
+		orb.animation.update(command, argument, time.Now())
+		return nil
+	}
+	orb.decoder.handleFrame = func(frame []byte) error {
+		orb.mutex.Lock()
+		defer orb.mutex.Unlock()
+
+		orb.frame = append([]byte{}, frame...)
+		orb.frameTime = time.Now()
+		return nil
+	}
+
+	return orb
+}
+
+// draw writes a single line to the terminal showing the state and brightness of the orb at the nominated time,
+// followed by the pixels of the last frame if the orb is being sent pixel frames.
+func (o *virtualOrb) draw(w io.Writer, now time.Time) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	command, level := o.animation.current(now)
+	state, found := stateNames[command]
+	if !found {
+		state = "waiting"
+	}
+
+	line := new(bytes.Buffer)
+	if len(o.frame) > 0 && now.Sub(o.frameTime) < frameTimeout {
+		state = "pixels"
+		level = 0.0
+		for i := 0; i+2 < len(o.frame); i += 3 {
+			level += float32(int(o.frame[i])+int(o.frame[i+1])+int(o.frame[i+2])) / (3.0 * 255.0)
+		}
+		level = level / float32(len(o.frame)/3)
+
+		for i := 0; i+2 < len(o.frame) && i/3 < virtualMaxPixels; i += 3 {
+			line.WriteString(ansiColour(o.frame[i:i+3], "●"))
+		}
+	} else {
+		line.WriteString(ansiColour(o.displayChannels(command, level), "●"))
+	}
+
+	filled := int(level*virtualBarWidth + 0.5)
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", virtualBarWidth-filled)
+
+	fmt.Fprintf(w, "\r\x1b[K%-10s %s %4.2f %s", state, bar, level, line.String())
+}
+
+// displayChannels returns the colour of the orb shown in the terminal, the colour of the look for the state at
+// the brightness played by the firmware.
+func (o *virtualOrb) displayChannels(command byte, level float32) []byte {
+	colour := o.look.colour(command, level)
+
+	channels := make([]byte, len(colour))
+	for i, c := range colour {
+		channels[i] = byte(math.Floor(float64(clampLevel(c*level))*255.0 + 0.5))
+	}
+
+	return channels
+}
+
+// ansiColour wraps the text in the escape codes that display it in the nominated 24 bit colour.
+func ansiColour(channels []byte, text string) string {
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm%s\x1b[0m", channels[0], channels[1], channels[2], text)
+}
+
+// virtualArduino creates a pseudo-terminal that speaks the same serial protocol as the arduino, and renders the
+// orb in the terminal. Point the Arduino Port in the neurone configuration at the printed path (or the
+// symlink) to drive it instead of a physical orb. Pseudo-terminals are available on linux and macOS.
+func virtualArduino(args []string) {
+	flags := flag.NewFlagSet("virtual-arduino", flag.ExitOnError)
+	link := flags.String("link", "", "create a symlink to the virtual serial port at this path")
+	configFile := flags.String("config", "", "neurone configuration file, used for the look of the orb")
+	flags.Parse(args)
+
+	config := defaultConfiguration()
+	if *configFile != "" {
+		var err error
+		config, err = parseConfiguration(*configFile)
+		if err != nil {
+			fmt.Printf("WARNING: Unable to parse configuration %s: %s\n", *configFile, err)
+		}
+	}
+
+	look, err := newLook(config.Lighting)
+	if err != nil {
+		fmt.Printf("WARNING: Invalid lighting configuration, using the default look: %s\n", err)
+		look, _ = newLook(defaultConfiguration().Lighting)
+	}
+
+	master, slaveName, err := openPty()
+	if err != nil {
+		fmt.Printf("ERROR: Unable to create virtual serial port: %s\n", err)
+		os.Exit(1)
+	}
+	defer master.Close()
+
+	// Hold the slave side open so the virtual port survives the neurone disconnecting and reconnecting.
+	slave, err := os.OpenFile(slaveName, os.O_RDWR, 0)
+	if err != nil {
+		fmt.Printf("ERROR: Unable to open virtual serial port: %s\n", err)
+		os.Exit(1)
+	}
+	defer slave.Close()
+
+	// A terminal left in cooked mode translates bytes within the binary commands, corrupting them.
+	err = makeRaw(slave)
+	if err != nil {
+		fmt.Printf("ERROR: Unable to put the virtual serial port into raw mode: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *link != "" {
+		os.Remove(*link)
+		err = os.Symlink(slaveName, *link)
+		if err != nil {
+			fmt.Printf("WARNING: Unable to link %s to the virtual serial port: %s\n", *link, err)
+		} else {
+			defer os.Remove(*link)
+			slaveName = *link
+		}
+	}
+
+	fmt.Printf("Virtual arduino listening on %s\n", slaveName)
+
+	orb := newVirtualOrb(look)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := master.Read(buf)
+			if err != nil {
+				fmt.Printf("\nERROR: Virtual serial port closed: %s\n", err)
+				os.Exit(1)
+			}
+
+			orb.decoder.Write(buf[:n])
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second / virtualFrameRate)
+	for now := range ticker.C {
+		orb.draw(os.Stdout, now)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// arduinoCommand encodes a command and its argument as they are sent over serial to the arduino.
+func arduinoCommand(command byte, argument float32) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(command)
+	binary.Write(buf, binary.LittleEndian, argument)
+
+	return buf.Bytes()
+}
+
+func TestVirtualOrb(t *testing.T) {
+	// The neurone has already applied the curve, the virtual orb mustn't apply it again.
+	lighting := defaultConfiguration().Lighting
+	lighting.Curve = CurveConfiguration{"exponential", 4.0, nil}
+	lighting.Palettes = map[string][][3]float32{"accumulate": {{0.0, 0.0, 1.0}}}
+	display, _ := newLook(lighting)
+	orb := newVirtualOrb(display)
+
+	orb.decoder.Write(arduinoCommand('e', 0.5))
+
+	var out bytes.Buffer
+	orb.draw(&out, time.Now())
+	if !strings.Contains(out.String(), "accumulate") || !strings.Contains(out.String(), "0.50") {
+		t.Errorf("incorrect orb for energy command %q", out.String())
+	}
+
+	if !strings.Contains(out.String(), "\x1b[38;2;0;0;128m") {
+		t.Errorf("orb not shown in the colour of the look %q", out.String())
+	}
+
+	frame := []byte{'f', 2, 0, 255, 255, 255, 0, 0, 0}
+	orb.decoder.Write(frame)
+
+	out.Reset()
+	orb.draw(&out, time.Now())
+	if !strings.Contains(out.String(), "pixels") || !strings.Contains(out.String(), "0.50") {
+		t.Errorf("incorrect orb for pixel frame %q", out.String())
+	}
+}
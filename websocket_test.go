@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) Clinton Freeman 2013
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this software and
+ * associated documentation files (the "Software"), to deal in the Software without restriction,
+ * including without limitation the rights to use, copy, modify, merge, publish, distribute,
+ * sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or
+ * substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+ * NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+ * NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialEvents opens a WebSocket connection to the event stream of the test server.
+func dialEvents(t *testing.T, server *httptest.Server, query string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("unable to connect to event stream: %s", err)
+	}
+
+	io.WriteString(conn, "GET /events"+query+" HTTP/1.1\r\nHost: neurone\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("unable to read handshake: %s", err)
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("websocket handshake failed with %d", response.StatusCode)
+	}
+
+	if response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("incorrect websocket accept key %s", response.Header.Get("Sec-WebSocket-Accept"))
+	}
+
+	return conn, reader
+}
+
+// readEvent reads a single unmasked text frame sent by the server.
+func readEvent(t *testing.T, conn net.Conn, reader *bufio.Reader) event {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatalf("unable to read event: %s", err)
+	}
+
+	if header[0] != 0x80|opText || header[1] > 126 {
+		t.Fatalf("unexpected frame header %x", header)
+	}
+
+	length := int(header[1])
+	if length == 126 {
+		extended := make([]byte, 2)
+		io.ReadFull(reader, extended)
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+
+	payload := make([]byte, length)
+	io.ReadFull(reader, payload)
+
+	var e event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		t.Fatalf("unable to decode event %s: %s", payload, err)
+	}
+
+	return e
+}
+
+func TestEventStream(t *testing.T) {
+	m := newMonitor(defaultConfiguration())
+	server := httptest.NewServer(http.HandlerFunc(m.serveEvents))
+	defer server.Close()
+
+	conn, reader := dialEvents(t, server, "?events=state,energy&rate=1")
+	defer conn.Close()
+
+	// Wait for the stream to subscribe before updating the neurone.
+	for i := 0; i < 100; i++ {
+		m.mutex.Lock()
+		subscribed := len(m.subscribers) == 1
+		m.mutex.Unlock()
+
+		if subscribed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	now := time.Now()
+	m.update("accumulate", 0.1, now)
+	m.update("accumulate", 0.2, now.Add(100*time.Millisecond))
+	m.update("accumulate", 0.3, now.Add(1100*time.Millisecond))
+	m.firingReceived(newImpulse("left", 0.5, ""))
+	m.update("cooldown", 0.0, now.Add(1200*time.Millisecond))
+
+	// The second energy update falls within the sampling interval, and received firings weren't subscribed to.
+	expected := []struct {
+		eventType string
+		energy    float32
+	}{{stateEvent, 0.1}, {energyEvent, 0.1}, {energyEvent, 0.3}, {stateEvent, 0.0}}
+
+	for _, x := range expected {
+		e := readEvent(t, conn, reader)
+		if e.Type != x.eventType || e.Energy != x.energy {
+			t.Errorf("incorrect event %s %f, expected %s %f", e.Type, e.Energy, x.eventType, x.energy)
+		}
+	}
+
+	// A masked close frame from the client is echoed back before the server hangs up.
+	conn.Write([]byte{0x80 | opClose, 0x80, 1, 2, 3, 4})
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			t.Fatalf("connection closed without a close frame: %s", err)
+		}
+
+		if header[0] == 0x80|opClose {
+			break
+		}
+		io.CopyN(ioutil.Discard, reader, int64(header[1]))
+	}
+}
+
+func TestWebsocketHandshakeRequired(t *testing.T) {
+	m := newMonitor(defaultConfiguration())
+
+	w := httptest.NewRecorder()
+	m.serveEvents(w, httptest.NewRequest("GET", "/events", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("plain request for events returned %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	m.serveEvents(w, httptest.NewRequest("GET", "/events?rate=fast", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid rate returned %d", w.Code)
+	}
+}